@@ -13,6 +13,15 @@ type Module struct {
 	Name     string         `json:"name"`
 	Services []Service      `json:"services"`
 	Configs  []ModuleConfig `json:"configs"`
+	Files    []ModuleFile   `json:"files,omitempty"`
+}
+
+// ModuleFile is a plain text config file that services in the module can
+// mount. the content is a go template, filled in with the values
+type ModuleFile struct {
+	Name     string                     `json:"name"`
+	Template string                     `json:"template"`
+	Values   map[string]expr.Expression `json:"values,omitempty"`
 }
 
 type ModuleConfig struct {
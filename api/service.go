@@ -14,17 +14,150 @@ type Env struct {
 	Value expr.Expression `json:"value"`
 }
 
+type Port struct {
+	Name string `json:"name,omitempty"`
+	Port int32  `json:"port"`
+	// defaults to port
+	TargetPort *int32 `json:"targetPort,omitempty"`
+	// TCP, UDP or SCTP. defaults to TCP
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// Expose makes a service reachable from outside the cluster through an ingress
+type Expose struct {
+	Host expr.Expression `json:"host"`
+	// defaults to /
+	Path *expr.Expression `json:"path,omitempty"`
+	// the name of the kubernetes.io/tls secret holding the certificate. tls is
+	// disabled if not set
+	Tls *expr.Expression `json:"tls,omitempty"`
+	// the name of the port to send traffic to. defaults to the first port
+	Port string `json:"port,omitempty"`
+}
+
+// Resources are the cpu and memory that a service asks for and is limited to
+type Resources struct {
+	Requests *ResourceList `json:"requests,omitempty"`
+	Limits   *ResourceList `json:"limits,omitempty"`
+}
+
+type ResourceList struct {
+	// a kubernetes quantity, like 500m or 1
+	Cpu *expr.Expression `json:"cpu,omitempty"`
+	// a kubernetes quantity, like 128Mi
+	Memory *expr.Expression `json:"memory,omitempty"`
+}
+
+type Toleration struct {
+	Key *expr.Expression `json:"key,omitempty"`
+	// Equal or Exists. defaults to Equal
+	Operator string           `json:"operator,omitempty"`
+	Value    *expr.Expression `json:"value,omitempty"`
+	// NoSchedule, PreferNoSchedule or NoExecute. empty matches every effect
+	Effect string `json:"effect,omitempty"`
+}
+
+type Probes struct {
+	// restarts the container when it fails
+	Liveness *Probe `json:"liveness,omitempty"`
+	// keeps traffic away from the container and holds back dependent services
+	// until it passes
+	Readiness *Probe `json:"readiness,omitempty"`
+	// holds off the other probes until the container has started
+	Startup *Probe `json:"startup,omitempty"`
+}
+
+// Probe checks on a container. exactly one of http, tcp and exec has to be set
+type Probe struct {
+	Http *HttpProbe `json:"http,omitempty"`
+	Tcp  *TcpProbe  `json:"tcp,omitempty"`
+	// a command run in the container, which passes if it exits with 0
+	Exec *string `json:"exec,omitempty"`
+
+	// durations in whole seconds, like 10s
+	InitialDelay string `json:"initialDelay,omitempty"`
+	Period       string `json:"period,omitempty"`
+	Timeout      string `json:"timeout,omitempty"`
+
+	SuccessThreshold int32 `json:"successThreshold,omitempty"`
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+}
+
+type HttpProbe struct {
+	// defaults to /
+	Path string `json:"path,omitempty"`
+	// the name of the port to probe. defaults to the first port
+	Port string `json:"port,omitempty"`
+}
+
+type TcpProbe struct {
+	// the name of the port to probe. defaults to the first port
+	Port string `json:"port,omitempty"`
+}
+
+// Volume is persistent storage. every replica gets its own, and it's kept
+// around when the service is redeployed
+type Volume struct {
+	Name string `json:"name"`
+	// where the volume is mounted in the container
+	Path string `json:"path"`
+	// a kubernetes quantity, like 1Gi
+	Size expr.Expression `json:"size"`
+	// defaults to the cluster's default storage class
+	StorageClass *expr.Expression `json:"storageClass,omitempty"`
+}
+
+// File mounts a string or secret value, or one of the module's files, into
+// the container. exactly one of value and file has to be set
+type File struct {
+	Path  string           `json:"path"`
+	Value *expr.Expression `json:"value,omitempty"`
+	// the name of a file declared by the module
+	File string `json:"file,omitempty"`
+}
+
+// Container runs next to the service's own container
+type Container struct {
+	Name    string           `json:"name"`
+	Image   expr.Expression  `json:"image"`
+	Command *expr.Expression `json:"command,omitempty"`
+	Env     []Env            `json:"env,omitempty"`
+}
+
 type ApiWingman struct {
 	Image expr.Expression
 }
 
 type Service struct {
-	Name     string            `json:"name"`
+	Name string `json:"name"`
+	// deployment, stateful, job or cronjob. defaults to deployment
+	Kind string `json:"kind,omitempty"`
+	// when a cronjob runs, in cron syntax
+	Schedule string            `json:"schedule,omitempty"`
 	Image    expr.Expression   `json:"image"`
 	Command  *expr.Expression  `json:"command"`
 	Requires []expr.Expression `json:"requires"`
 	Wingman  *ApiWingman       `json:"wingman,omitempty"`
 	Env      []Env             `json:"env"`
+	Ports    []Port            `json:"ports,omitempty"`
+	Expose   *Expose           `json:"expose,omitempty"`
+	// defaults to 1
+	Replicas     *expr.Expression           `json:"replicas,omitempty"`
+	Resources    *Resources                 `json:"resources,omitempty"`
+	NodeSelector map[string]expr.Expression `json:"nodeSelector,omitempty"`
+	Tolerations  []Toleration               `json:"tolerations,omitempty"`
+	Probes       *Probes                    `json:"probes,omitempty"`
+	// only stateful services can have volumes
+	Volumes []Volume `json:"volumes,omitempty"`
+	Files   []File   `json:"files,omitempty"`
+	// run to completion, in order, before the service starts
+	InitContainers []Container `json:"initContainers,omitempty"`
+	// run for as long as the service does. they're started before the init
+	// containers, so those can use them too. needs kubernetes 1.29 or newer
+	Sidecars []Container `json:"sidecars,omitempty"`
+	// how long to wait for the service to become ready, like 5m. defaults to
+	// the manager's deploy timeout
+	Timeout string `json:"timeout,omitempty"`
 }
 
 func (s *Service) RequiredServices(ctx context.Context, deps expr.EvaluationContext) ([]state.ServiceRef, error) {
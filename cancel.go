@@ -8,31 +8,61 @@ import (
 	"github.com/BSFishy/mora-manager/util"
 )
 
-// poll the db to see if the current deployment has been cancelled. if so,
-// cancel the deployment
-//
-// TODO: there could still technically be some contention here, where a new
-// deployment starts trying to deploy stuff while the previous one is still in
-// the 2 second tick period and they try to fight over deploying the same
-// resource. this is probably a pretty niche edge case but still a problem
-func (a *App) handleDeployCancel(ctx context.Context, cancel context.CancelFunc, d *model.Deployment) {
+const heartbeatInterval = 2 * time.Second
+
+// handleDeployCancel cancels the deployment as soon as it gets cancelled in the
+// database, rather than waiting for the next heartbeat
+func (a *App) handleDeployCancel(ctx context.Context, cancel context.CancelFunc, cancelled <-chan struct{}) {
 	logger := util.LogFromCtx(ctx)
-	ticker := time.NewTicker(2 * time.Second)
+
+	select {
+	case <-ctx.Done():
+	case <-cancelled:
+		logger.Info("ending cancelled deployment")
+		cancel()
+	}
+}
+
+// holdLease keeps the job's lease alive while its deployment runs. the
+// deployment is cancelled if the lease is lost to another worker, or if it
+// couldn't be extended for so long that another worker could've taken it.
+// cancelled deployments are also caught here in case the notification was
+// missed
+func (a *App) holdLease(ctx context.Context, cancel context.CancelFunc, job *model.Job) {
+	logger := util.LogFromCtx(ctx)
+	ticker := time.NewTicker(heartbeatInterval)
 	defer ticker.Stop()
 
+	lastHeartbeat := time.Now()
+
 	for {
 		select {
 		case <-ctx.Done():
 			// context cancelled somewhere else
 			return
 		case <-ticker.C:
-			isCancelled, err := d.IsCancelled(ctx, a.db)
+			held, status, err := job.Heartbeat(ctx, a.db, a.lease)
 			if err != nil {
-				logger.Error("failed to check if deployment is cancelled", "err", err)
+				logger.Error("failed to extend job lease", "err", err)
+
+				if time.Since(lastHeartbeat) >= a.lease {
+					logger.Warn("job lease expired, another worker may take over")
+					cancel()
+					return
+				}
+
 				continue
 			}
 
-			if isCancelled {
+			lastHeartbeat = time.Now()
+
+			if !held {
+				logger.Warn("lost job lease, another worker took over")
+				cancel()
+				return
+			}
+
+			if status == model.Cancelled {
 				logger.Info("ending cancelled deployment")
 				cancel()
 				return
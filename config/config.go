@@ -1,15 +1,46 @@
 package config
 
 import (
+	"slices"
+
 	"github.com/BSFishy/mora-manager/point"
+	"github.com/BSFishy/mora-manager/state"
 	"github.com/BSFishy/mora-manager/value"
 )
 
 type Config struct {
+	// services in topological order. the dependency graph itself is kept on each
+	// service through ServiceConfig.Requires
 	Services []ServiceConfig
 	Configs  []point.Point
 }
 
+// Dependents lists the services that require the given one
+func (c *Config) Dependents(ref state.ServiceRef) []state.ServiceRef {
+	dependents := []state.ServiceRef{}
+	for _, service := range c.Services {
+		if slices.Contains(service.Requires, ref) {
+			dependents = append(dependents, service.Ref())
+		}
+	}
+
+	return dependents
+}
+
+// HasDependencyGraph reports whether any service requires another one. configs
+// that were stored before dependencies were tracked don't, and they have to be
+// deployed one service at a time in the order they were stored in. so does a
+// config where nothing depends on anything, which only costs parallelism
+func (c *Config) HasDependencyGraph() bool {
+	for _, service := range c.Services {
+		if len(service.Requires) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (c *Config) FindConfig(moduleName, identifier string) *point.Point {
 	for _, config := range c.Configs {
 		if config.ModuleName == moduleName && config.Identifier == identifier {
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/BSFishy/mora-manager/api"
+	"github.com/BSFishy/mora-manager/expr"
+	"github.com/BSFishy/mora-manager/point"
+	"github.com/BSFishy/mora-manager/util/shlex"
+	"github.com/BSFishy/mora-manager/value"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ContainerConfig is an init container or sidecar of a service
+type ContainerConfig struct {
+	Name    string
+	Image   expr.Expression
+	Command *expr.Expression
+	Env     []api.Env
+}
+
+type ContainerDefinition struct {
+	Name    string
+	Image   string
+	Command []string
+	Env     []MaterializedEnv
+}
+
+// containersFromApi validates the extra containers of a service. names is
+// shared between init containers and sidecars, since they all end up in the
+// same pod. main is the name of the service's own container, which is taken
+// too
+func containersFromApi(apiContainers []api.Container, main string, names map[string]bool) ([]ContainerConfig, error) {
+	containers := make([]ContainerConfig, len(apiContainers))
+	for i, c := range apiContainers {
+		if errs := validation.IsDNS1123Label(c.Name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid container name %s: %s", c.Name, strings.Join(errs, ", "))
+		}
+
+		if c.Name == main {
+			return nil, fmt.Errorf("container name %s is taken by the service's own container", c.Name)
+		}
+
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate container name %s", c.Name)
+		}
+
+		names[c.Name] = true
+
+		containers[i] = ContainerConfig{
+			Name:    c.Name,
+			Image:   c.Image,
+			Command: c.Command,
+			Env:     c.Env,
+		}
+	}
+
+	return containers, nil
+}
+
+// Evaluate evaluates the image, command and env of a container. the service's
+// own container goes through here too
+func (c *ContainerConfig) Evaluate(ctx context.Context, deps expr.EvaluationContext) (*ContainerDefinition, []point.Point, error) {
+	configPoints := []point.Point{}
+
+	image, imageCfp, err := c.Image.Evaluate(ctx, deps)
+	if err != nil {
+		return nil, nil, fmt.Errorf("evaluating image: %w", err)
+	}
+
+	if len(imageCfp) == 0 && image.Kind() != value.String {
+		return nil, nil, errors.New("invalid image property")
+	}
+
+	configPoints = append(configPoints, imageCfp...)
+
+	var command []string
+	if c.Command != nil {
+		cmd, cmdCfp, err := c.Command.Evaluate(ctx, deps)
+		if err != nil {
+			return nil, nil, fmt.Errorf("evaluating command: %w", err)
+		}
+
+		if len(cmdCfp) == 0 && cmd.Kind() != value.String {
+			return nil, nil, errors.New("invalid command property")
+		}
+
+		configPoints = append(configPoints, cmdCfp...)
+		cmdString := cmd.String()
+
+		command, err = shlex.Split(cmdString)
+		if err != nil {
+			return nil, nil, fmt.Errorf("splitting command: %w", err)
+		}
+	}
+
+	envs := []MaterializedEnv{}
+	for _, e := range c.Env {
+		ev, envCfp, err := e.Value.Evaluate(ctx, deps)
+		if err != nil {
+			return nil, nil, fmt.Errorf("evaluating env %s: %w", e.Name, err)
+		}
+
+		configPoints = append(configPoints, envCfp...)
+
+		if len(envCfp) == 0 {
+			switch ev.Kind() {
+			case value.String:
+				fallthrough
+			case value.Secret:
+				envs = append(envs, MaterializedEnv{
+					Name:  e.Name,
+					Value: ev,
+				})
+			default:
+				return nil, nil, fmt.Errorf("invalid kind for env %s: %s", e.Name, ev.Kind())
+			}
+		}
+	}
+
+	if len(configPoints) > 0 {
+		return nil, configPoints, nil
+	}
+
+	return &ContainerDefinition{
+		Name:    c.Name,
+		Image:   image.String(),
+		Command: command,
+		Env:     envs,
+	}, nil, nil
+}
+
+func evaluateContainers(ctx context.Context, deps expr.EvaluationContext, containers []ContainerConfig) ([]ContainerDefinition, []point.Point, error) {
+	configPoints := []point.Point{}
+	definitions := make([]ContainerDefinition, 0, len(containers))
+
+	for _, c := range containers {
+		definition, cfp, err := c.Evaluate(ctx, deps)
+		if err != nil {
+			return nil, nil, fmt.Errorf("evaluating container %s: %w", c.Name, err)
+		}
+
+		configPoints = append(configPoints, cfp...)
+		if definition != nil {
+			definitions = append(definitions, *definition)
+		}
+	}
+
+	if len(configPoints) > 0 {
+		return nil, configPoints, nil
+	}
+
+	return definitions, nil, nil
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/BSFishy/mora-manager/api"
+	"github.com/BSFishy/mora-manager/kube"
+)
+
+func TestContainersFromApi(t *testing.T) {
+	mainContainer := kube.MainContainerName("app", "web")
+
+	tests := []struct {
+		name     string
+		init     []string
+		sidecars []string
+		valid    bool
+	}{
+		{"none", nil, nil, true},
+		{"init containers and sidecars", []string{"migrate"}, []string{"proxy", "logs"}, true},
+		{"invalid name", []string{"Migrate"}, nil, false},
+		{"main container's name", []string{mainContainer}, nil, false},
+		{"main container's name on a sidecar", nil, []string{mainContainer}, false},
+		{"duplicate init container", []string{"migrate", "migrate"}, nil, false},
+		{"init container and sidecar with the same name", []string{"proxy"}, []string{"proxy"}, false},
+	}
+
+	containers := func(names []string) []api.Container {
+		containers := make([]api.Container, len(names))
+		for i, name := range names {
+			containers[i] = api.Container{Name: name}
+		}
+
+		return containers
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names := map[string]bool{}
+
+			initContainers, err := containersFromApi(containers(tt.init), mainContainer, names)
+			if err == nil {
+				var sidecars []ContainerConfig
+				sidecars, err = containersFromApi(containers(tt.sidecars), mainContainer, names)
+				if err == nil && (len(initContainers) != len(tt.init) || len(sidecars) != len(tt.sidecars)) {
+					t.Errorf("expected %d init containers and %d sidecars, got %d and %d", len(tt.init), len(tt.sidecars), len(initContainers), len(sidecars))
+				}
+			}
+
+			if tt.valid && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+
+			if !tt.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -2,20 +2,48 @@ package config
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/BSFishy/mora-manager/core"
 	"github.com/BSFishy/mora-manager/def"
 	"github.com/BSFishy/mora-manager/kube"
+	"github.com/BSFishy/mora-manager/state"
 	"github.com/BSFishy/mora-manager/util"
+	"github.com/BSFishy/mora-manager/value"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 )
 
+// the key that module files keep their content under
+const moduleFileKey = "content"
+
 type ServiceDefinition struct {
-	Image   string
-	Command []string
-	Env     []MaterializedEnv
+	Kind       def.Workload
+	Schedule   string
+	Image      string
+	Command    []string
+	Env        []MaterializedEnv
+	Ports      []def.Port
+	Expose     *ExposeDefinition
+	Scheduling def.Scheduling
+	Probes     def.Probes
+	Volumes    []def.Volume
+	Files      []MaterializedFile
+	// see def.Pod.Checksum
+	Checksum string
+
+	InitContainers []ContainerDefinition
+	Sidecars       []ContainerDefinition
+}
+
+type ExposeDefinition struct {
+	Host      string
+	Path      string
+	TlsSecret string
+	Port      int32
 }
 
 func (s *ServiceDefinition) Materialize(deps interface {
@@ -23,19 +51,180 @@ func (s *ServiceDefinition) Materialize(deps interface {
 	core.HasServiceName
 },
 ) *kube.MaterializedService {
-	env := make([]def.Env, len(s.Env))
-	for i, e := range s.Env {
-		env[i] = def.Env{
+	env, references := materializeEnv(s.Env, []def.ResourceRef{})
+	initContainers, references := materializeContainers(s.InitContainers, references)
+	sidecars, references := materializeContainers(s.Sidecars, references)
+
+	// string files are put into a config map for the service and module files
+	// get one of their own, while secret files are mounted straight from the
+	// secret
+	files := make([]def.File, len(s.Files))
+	configMapData := map[string]string{}
+	configMap := kube.NewConfigMap(deps, fmt.Sprintf("%s-files", deps.GetServiceName()), configMapData)
+	moduleFiles := []kube.Resource[corev1.ConfigMap]{}
+	for i, f := range s.Files {
+		files[i] = def.File{
+			Path: f.Path,
+		}
+
+		switch {
+		case f.Module != nil:
+			// suffixed differently from the service's -files map so the two can't
+			// end up with the same name
+			moduleFile := kube.NewModuleConfigMap(deps, fmt.Sprintf("%s-module-file", f.Module.Name), map[string]string{
+				moduleFileKey: f.Module.Content,
+			})
+
+			if !slices.ContainsFunc(moduleFiles, func(c kube.Resource[corev1.ConfigMap]) bool {
+				return c.Name() == moduleFile.Name()
+			}) {
+				moduleFiles = append(moduleFiles, moduleFile)
+			}
+
+			files[i].ConfigMap = moduleFile.Name()
+			files[i].Key = moduleFileKey
+		case f.Value.Kind() == value.Secret:
+			files[i].Secret = f.Value.String()
+			references = append(references, def.ResourceRef{
+				Kind: def.KindSecret,
+				Name: f.Value.String(),
+			})
+		default:
+			key := fmt.Sprintf("file-%d", i)
+			configMapData[key] = f.Value.String()
+			files[i].ConfigMap = configMap.Name()
+			files[i].Key = key
+		}
+	}
+
+	pod := def.Pod{
+		Image:      s.Image,
+		Command:    s.Command,
+		Env:        env,
+		Ports:      s.Ports,
+		Probes:     s.Probes,
+		Volumes:    s.Volumes,
+		Files:      files,
+		Scheduling: s.Scheduling,
+		Checksum:   s.Checksum,
+
+		InitContainers: initContainers,
+		Sidecars:       sidecars,
+	}
+
+	service := &kube.MaterializedService{
+		References: references,
+	}
+
+	service.ConfigMaps = moduleFiles
+	if len(configMapData) > 0 {
+		service.ConfigMaps = append(service.ConfigMaps, configMap)
+	}
+
+	var svc kube.Resource[corev1.Service]
+	switch s.Kind {
+	case def.WorkloadStateful:
+		// stateful sets always need a headless service to give their pods stable
+		// addresses, even if nothing else talks to them
+		svc = kube.NewHeadlessService(deps, s.Ports)
+		service.StatefulSets = []kube.Resource[appsv1.StatefulSet]{
+			kube.NewStatefulSet(deps, pod, svc.Name()),
+		}
+	case def.WorkloadJob:
+		service.Jobs = []kube.Resource[batchv1.Job]{
+			kube.NewJob(deps, pod),
+		}
+	case def.WorkloadCronJob:
+		service.CronJobs = []kube.Resource[batchv1.CronJob]{
+			kube.NewCronJob(deps, s.Schedule, pod),
+		}
+	default:
+		service.Deployments = []kube.Resource[appsv1.Deployment]{
+			kube.NewDeployment(deps, pod, false, ""),
+		}
+
+		// other services can only reach this one if it says what it listens on
+		if len(s.Ports) > 0 {
+			svc = kube.NewService(deps, false, s.Ports)
+		}
+	}
+
+	if svc != nil {
+		service.Services = []kube.Resource[corev1.Service]{svc}
+
+		if s.Expose != nil {
+			service.Ingresses = []kube.Resource[networkingv1.Ingress]{
+				kube.NewIngress(deps, s.Expose.Host, s.Expose.Path, s.Expose.TlsSecret, svc.Name(), s.Expose.Port),
+			}
+		}
+	}
+
+	return service
+}
+
+// MaterializeNetworkPolicy isolates the service so that only the services that
+// require it can connect, on top of whatever is exposed through the ingress
+func (s *ServiceDefinition) MaterializeNetworkPolicy(deps interface {
+	core.HasModuleName
+	core.HasServiceName
+}, dependents []state.ServiceRef,
+) kube.Resource[networkingv1.NetworkPolicy] {
+	peers := make([]kube.NetworkPeer, len(dependents))
+	for i, dependent := range dependents {
+		peers[i] = kube.NetworkPeer{
+			Module:  dependent.Module,
+			Service: dependent.Service,
+		}
+	}
+
+	exposed := []def.Port{}
+	if s.Expose != nil {
+		for _, port := range s.Ports {
+			if port.Port == s.Expose.Port {
+				exposed = append(exposed, port)
+			}
+		}
+	}
+
+	return kube.NewNetworkPolicy(deps, peers, exposed)
+}
+
+// materializeEnv converts the env for a pod, adding the secrets it uses to
+// references
+func materializeEnv(env []MaterializedEnv, references []def.ResourceRef) ([]def.Env, []def.ResourceRef) {
+	result := make([]def.Env, len(env))
+	for i, e := range env {
+		result[i] = def.Env{
 			Name:  e.Name,
 			Value: e.Value,
 		}
+
+		if e.Value.Kind() == value.Secret {
+			references = append(references, def.ResourceRef{
+				Kind: def.KindSecret,
+				Name: e.Value.String(),
+			})
+		}
 	}
 
-	return &kube.MaterializedService{
-		Deployments: []kube.Resource[appsv1.Deployment]{
-			kube.NewDeployment(deps, s.Image, s.Command, env, false, ""),
-		},
+	return result, references
+}
+
+func materializeContainers(containers []ContainerDefinition, references []def.ResourceRef) ([]def.Container, []def.ResourceRef) {
+	result := make([]def.Container, len(containers))
+	for i, c := range containers {
+		var env []def.Env
+		env, references = materializeEnv(c.Env, references)
+
+		result[i] = def.Container{
+			Name:    c.Name,
+			Image:   c.Image,
+			Command: c.Command,
+			Env:     env,
+		}
 	}
+
+	return result, references
 }
 
 type WingmanDefinition struct {
@@ -72,10 +261,10 @@ func (w *WingmanDefinition) MaterializeWingman(deps interface {
 		},
 		Deployments: []kube.Resource[appsv1.Deployment]{
 			// TODO: support commands for wingmen
-			kube.NewDeployment(deps, w.Image, nil, nil, true, name),
+			kube.NewDeployment(deps, def.Pod{Image: w.Image}, true, name),
 		},
 		Services: []kube.Resource[corev1.Service]{
-			kube.NewService(deps, true),
+			kube.NewService(deps, true, nil),
 		},
 	}
 }
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/BSFishy/mora-manager/state"
+)
+
+// UnknownServiceError is returned when a service requires a service that isn't
+// declared in any module
+type UnknownServiceError struct {
+	Service  state.ServiceRef
+	Requires state.ServiceRef
+}
+
+func (e *UnknownServiceError) Error() string {
+	return fmt.Sprintf("%s requires unknown service %s", e.Service, e.Requires)
+}
+
+// CycleError is returned when the requires graph contains a cycle. the path
+// starts and ends with the same service
+type CycleError struct {
+	Path []state.ServiceRef
+}
+
+func (e *CycleError) Error() string {
+	path := make([]string, len(e.Path))
+	for i, ref := range e.Path {
+		path[i] = ref.String()
+	}
+
+	return fmt.Sprintf("dependency cycle: %s", strings.Join(path, " -> "))
+}
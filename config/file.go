@@ -0,0 +1,244 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/BSFishy/mora-manager/api"
+	"github.com/BSFishy/mora-manager/expr"
+	"github.com/BSFishy/mora-manager/kube"
+	"github.com/BSFishy/mora-manager/point"
+	"github.com/BSFishy/mora-manager/value"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+type ServiceFile struct {
+	Path string
+	// exactly one of these is set
+	Value  *expr.Expression
+	Module *ModuleFile
+}
+
+// ModuleFile is a file declared by a module. it's copied into every service
+// that mounts it
+type ModuleFile struct {
+	Name     string
+	Template string
+	Values   map[string]expr.Expression
+}
+
+type MaterializedFile struct {
+	Path string
+	// a string or secret value
+	Value value.Value
+	// or the rendered content of a module file
+	Module *MaterializedModuleFile
+}
+
+type MaterializedModuleFile struct {
+	Name    string
+	Content string
+}
+
+func moduleFilesFromApi(apiFiles []api.ModuleFile) (map[string]*ModuleFile, error) {
+	files := map[string]*ModuleFile{}
+	for _, f := range apiFiles {
+		if errs := validation.IsDNS1123Label(f.Name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid file name %s: %s", f.Name, strings.Join(errs, ", "))
+		}
+
+		if files[f.Name] != nil {
+			return nil, fmt.Errorf("duplicate file name %s", f.Name)
+		}
+
+		if _, err := parseFileTemplate(f.Name, f.Template); err != nil {
+			return nil, fmt.Errorf("invalid template for file %s: %w", f.Name, err)
+		}
+
+		files[f.Name] = &ModuleFile{
+			Name:     f.Name,
+			Template: f.Template,
+			Values:   f.Values,
+		}
+	}
+
+	return files, nil
+}
+
+func parseFileTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+func filesFromApi(apiFiles []api.File, volumes []api.Volume, moduleFiles map[string]*ModuleFile) ([]ServiceFile, error) {
+	paths := map[string]bool{}
+	for _, v := range volumes {
+		paths[path.Clean(v.Path)] = true
+	}
+
+	files := make([]ServiceFile, len(apiFiles))
+	for i, f := range apiFiles {
+		if !path.IsAbs(f.Path) {
+			return nil, fmt.Errorf("file %s needs an absolute path", f.Path)
+		}
+
+		if paths[path.Clean(f.Path)] {
+			return nil, fmt.Errorf("duplicate path %s", f.Path)
+		}
+
+		paths[path.Clean(f.Path)] = true
+
+		if (f.Value == nil) == (f.File == "") {
+			return nil, fmt.Errorf("file %s needs exactly one of value and file", f.Path)
+		}
+
+		files[i] = ServiceFile{
+			Path:  f.Path,
+			Value: f.Value,
+		}
+
+		if f.File != "" {
+			moduleFile, ok := moduleFiles[f.File]
+			if !ok {
+				return nil, fmt.Errorf("unknown file %s", f.File)
+			}
+
+			files[i].Module = moduleFile
+		}
+	}
+
+	return files, nil
+}
+
+// evaluateFiles evaluates the content of every file. it also returns a
+// checksum of the content, which changes the pod whenever the content changes.
+// secrets keep their name when their value changes, so they have to be read
+// to notice
+func (s *ServiceConfig) evaluateFiles(ctx context.Context, deps expr.EvaluationContext) ([]MaterializedFile, string, []point.Point, error) {
+	configPoints := []point.Point{}
+	files := make([]MaterializedFile, len(s.Files))
+	hash := sha256.New()
+
+	for i, f := range s.Files {
+		files[i] = MaterializedFile{
+			Path: f.Path,
+		}
+
+		if f.Module != nil {
+			content, cfp, err := f.Module.render(ctx, deps)
+			if err != nil {
+				return nil, "", nil, fmt.Errorf("rendering file %s: %w", f.Module.Name, err)
+			}
+
+			configPoints = append(configPoints, cfp...)
+
+			fmt.Fprintf(hash, "%s\x00file\x00%s\x00%d\x00%s", f.Path, f.Module.Name, len(content), content)
+			files[i].Module = &MaterializedModuleFile{
+				Name:    f.Module.Name,
+				Content: content,
+			}
+
+			continue
+		}
+
+		v, cfp, err := f.Value.Evaluate(ctx, deps)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("evaluating file %s: %w", f.Path, err)
+		}
+
+		configPoints = append(configPoints, cfp...)
+		if len(cfp) > 0 {
+			continue
+		}
+
+		var content []byte
+		switch v.Kind() {
+		case value.String:
+			content = []byte(v.String())
+		case value.Secret:
+			content, err = kube.GetSecret(ctx, deps, v.String())
+			if err != nil {
+				return nil, "", nil, fmt.Errorf("reading secret for file %s: %w", f.Path, err)
+			}
+		default:
+			return nil, "", nil, fmt.Errorf("invalid kind for file %s: %s", f.Path, v.Kind())
+		}
+
+		fmt.Fprintf(hash, "%s\x00%s\x00%s\x00%d\x00", f.Path, v.Kind(), v.String(), len(content))
+		hash.Write(content)
+
+		files[i].Value = v
+	}
+
+	if len(configPoints) > 0 {
+		return nil, "", configPoints, nil
+	}
+
+	if len(files) == 0 {
+		return files, "", nil, nil
+	}
+
+	return files, hex.EncodeToString(hash.Sum(nil)), nil, nil
+}
+
+// render evaluates the values and fills them into the template. the content
+// ends up in a config map, so secrets can't be used
+func (m *ModuleFile) render(ctx context.Context, deps expr.EvaluationContext) (string, []point.Point, error) {
+	configPoints := []point.Point{}
+	values := map[string]any{}
+
+	// sorted so that config points come out in the same order every time
+	keys := make([]string, 0, len(m.Values))
+	for key := range m.Values {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		e := m.Values[key]
+		v, cfp, err := e.Evaluate(ctx, deps)
+		if err != nil {
+			return "", nil, fmt.Errorf("evaluating value %s: %w", key, err)
+		}
+
+		configPoints = append(configPoints, cfp...)
+		if len(cfp) > 0 {
+			continue
+		}
+
+		switch v.Kind() {
+		case value.String, value.Identifier:
+			values[key] = v.String()
+		case value.Integer:
+			values[key] = v.Integer()
+		case value.Boolean:
+			values[key] = v.Boolean()
+		case value.Secret:
+			return "", nil, fmt.Errorf("value %s is a secret, which would end up in plain text", key)
+		default:
+			return "", nil, fmt.Errorf("invalid kind for value %s: %s", key, v.Kind())
+		}
+	}
+
+	if len(configPoints) > 0 {
+		return "", configPoints, nil
+	}
+
+	tmpl, err := parseFileTemplate(m.Name, m.Template)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var content strings.Builder
+	if err = tmpl.Execute(&content, values); err != nil {
+		return "", nil, fmt.Errorf("executing template: %w", err)
+	}
+
+	return content.String(), nil, nil
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/BSFishy/mora-manager/api"
+	"github.com/BSFishy/mora-manager/expr"
+)
+
+func TestModuleFilesFromApi(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []api.ModuleFile
+		valid bool
+	}{
+		{"none", nil, true},
+		{"template", []api.ModuleFile{{Name: "nginx", Template: "listen {{ .port }};"}}, true},
+		{"invalid name", []api.ModuleFile{{Name: "nginx.conf"}}, false},
+		{"duplicate name", []api.ModuleFile{{Name: "nginx"}, {Name: "nginx"}}, false},
+		{"invalid template", []api.ModuleFile{{Name: "nginx", Template: "listen {{ .port };"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := moduleFilesFromApi(tt.files)
+			if !tt.valid {
+				if err == nil {
+					t.Errorf("expected an error, got %v", files)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if len(files) != len(tt.files) {
+				t.Errorf("expected %d files, got %d", len(tt.files), len(files))
+			}
+		})
+	}
+}
+
+func TestFilesFromApi(t *testing.T) {
+	value := &expr.Expression{Atom: &expr.Atom{String: stringPtr("debug = true")}}
+	moduleFiles := map[string]*ModuleFile{
+		"nginx": {Name: "nginx", Template: "listen 80;"},
+	}
+
+	tests := []struct {
+		name    string
+		files   []api.File
+		volumes []api.Volume
+		module  []bool
+		valid   bool
+	}{
+		{"none", nil, nil, []bool{}, true},
+		{"value", []api.File{{Path: "/etc/app.toml", Value: value}}, nil, []bool{false}, true},
+		{"module file", []api.File{{Path: "/etc/nginx/nginx.conf", File: "nginx"}}, nil, []bool{true}, true},
+		{
+			"next to a volume",
+			[]api.File{{Path: "/data/app.toml", Value: value}},
+			[]api.Volume{{Name: "data", Path: "/data"}},
+			[]bool{false},
+			true,
+		},
+		{"relative path", []api.File{{Path: "etc/app.toml", Value: value}}, nil, nil, false},
+		{"duplicate path", []api.File{{Path: "/etc/app.toml", Value: value}, {Path: "/etc/./app.toml", Value: value}}, nil, nil, false},
+		{"volume path", []api.File{{Path: "/data/", Value: value}}, []api.Volume{{Name: "data", Path: "/data"}}, nil, false},
+		{"neither value nor file", []api.File{{Path: "/etc/app.toml"}}, nil, nil, false},
+		{"both value and file", []api.File{{Path: "/etc/app.toml", Value: value, File: "nginx"}}, nil, nil, false},
+		{"unknown file", []api.File{{Path: "/etc/app.toml", File: "haproxy"}}, nil, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := filesFromApi(tt.files, tt.volumes, moduleFiles)
+			if !tt.valid {
+				if err == nil {
+					t.Errorf("expected an error, got %v", files)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if len(files) != len(tt.module) {
+				t.Fatalf("expected %d files, got %d", len(tt.module), len(files))
+			}
+
+			for i, file := range files {
+				if file.Path != tt.files[i].Path {
+					t.Errorf("expected path %s, got %s", tt.files[i].Path, file.Path)
+				}
+
+				if (file.Module != nil) != tt.module[i] {
+					t.Errorf("expected %s to come from a module file: %t", file.Path, tt.module[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BSFishy/mora-manager/api"
+	"github.com/BSFishy/mora-manager/def"
+	"github.com/BSFishy/mora-manager/util/shlex"
+)
+
+// probesFromApi validates the probes of a service and resolves the ports they
+// check on
+func probesFromApi(apiProbes *api.Probes, ports []def.Port) (def.Probes, error) {
+	if apiProbes == nil {
+		return def.Probes{}, nil
+	}
+
+	liveness, err := probeFromApi(apiProbes.Liveness, ports)
+	if err != nil {
+		return def.Probes{}, fmt.Errorf("invalid liveness probe: %w", err)
+	}
+
+	readiness, err := probeFromApi(apiProbes.Readiness, ports)
+	if err != nil {
+		return def.Probes{}, fmt.Errorf("invalid readiness probe: %w", err)
+	}
+
+	startup, err := probeFromApi(apiProbes.Startup, ports)
+	if err != nil {
+		return def.Probes{}, fmt.Errorf("invalid startup probe: %w", err)
+	}
+
+	// kubernetes only lets readiness probes pass more than once
+	if liveness != nil && liveness.SuccessThreshold > 1 {
+		return def.Probes{}, errors.New("liveness probes can't have a success threshold above 1")
+	}
+
+	if startup != nil && startup.SuccessThreshold > 1 {
+		return def.Probes{}, errors.New("startup probes can't have a success threshold above 1")
+	}
+
+	return def.Probes{
+		Liveness:  liveness,
+		Readiness: readiness,
+		Startup:   startup,
+	}, nil
+}
+
+func probeFromApi(apiProbe *api.Probe, ports []def.Port) (*def.Probe, error) {
+	if apiProbe == nil {
+		return nil, nil
+	}
+
+	handlers := 0
+	probe := def.Probe{}
+
+	if apiProbe.Http != nil {
+		handlers++
+
+		port, err := probePort(ports, apiProbe.Http.Port)
+		if err != nil {
+			return nil, err
+		}
+
+		path := apiProbe.Http.Path
+		if path == "" {
+			path = "/"
+		}
+
+		probe.Http = &def.HttpProbe{
+			Path: path,
+			Port: port,
+		}
+	}
+
+	if apiProbe.Tcp != nil {
+		handlers++
+
+		port, err := probePort(ports, apiProbe.Tcp.Port)
+		if err != nil {
+			return nil, err
+		}
+
+		probe.Tcp = &def.TcpProbe{
+			Port: port,
+		}
+	}
+
+	if apiProbe.Exec != nil {
+		handlers++
+
+		command, err := shlex.Split(*apiProbe.Exec)
+		if err != nil {
+			return nil, fmt.Errorf("splitting exec: %w", err)
+		}
+
+		if len(command) == 0 {
+			return nil, errors.New("empty exec command")
+		}
+
+		probe.Exec = command
+	}
+
+	if handlers != 1 {
+		return nil, errors.New("exactly one of http, tcp and exec has to be set")
+	}
+
+	var err error
+	if probe.InitialDelaySeconds, err = probeSeconds(apiProbe.InitialDelay, 0); err != nil {
+		return nil, fmt.Errorf("invalid initial delay: %w", err)
+	}
+
+	if probe.PeriodSeconds, err = probeSeconds(apiProbe.Period, 1); err != nil {
+		return nil, fmt.Errorf("invalid period: %w", err)
+	}
+
+	if probe.TimeoutSeconds, err = probeSeconds(apiProbe.Timeout, 1); err != nil {
+		return nil, fmt.Errorf("invalid timeout: %w", err)
+	}
+
+	if apiProbe.SuccessThreshold < 0 || apiProbe.FailureThreshold < 0 {
+		return nil, errors.New("thresholds can't be negative")
+	}
+
+	probe.SuccessThreshold = apiProbe.SuccessThreshold
+	probe.FailureThreshold = apiProbe.FailureThreshold
+
+	return &probe, nil
+}
+
+func probePort(ports []def.Port, name string) (int32, error) {
+	if len(ports) == 0 {
+		return 0, errors.New("network probes need a port")
+	}
+
+	port, err := findPort(ports, name)
+	if err != nil {
+		return 0, err
+	}
+
+	// probes go straight to the container, not through the service
+	return port.TargetPort, nil
+}
+
+// probeSeconds parses a duration into the whole seconds that kubernetes wants
+func probeSeconds(duration string, minimum int32) (int32, error) {
+	if duration == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return 0, err
+	}
+
+	if d%time.Second != 0 {
+		return 0, fmt.Errorf("%s isn't a whole number of seconds", duration)
+	}
+
+	seconds := int32(d / time.Second)
+	if seconds < minimum {
+		return 0, fmt.Errorf("has to be at least %ds", minimum)
+	}
+
+	return seconds, nil
+}
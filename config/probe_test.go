@@ -0,0 +1,128 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/BSFishy/mora-manager/api"
+	"github.com/BSFishy/mora-manager/def"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestProbesFromApi(t *testing.T) {
+	ports := []def.Port{
+		{Name: "http", Port: 80, TargetPort: 8080, Protocol: "TCP"},
+		{Name: "grpc", Port: 9000, TargetPort: 9001, Protocol: "TCP"},
+	}
+
+	tests := []struct {
+		name   string
+		probes *api.Probes
+		ports  []def.Port
+		want   def.Probes
+		valid  bool
+	}{
+		{"none", nil, ports, def.Probes{}, true},
+		{
+			"http on the first port",
+			&api.Probes{Readiness: &api.Probe{Http: &api.HttpProbe{}}},
+			ports,
+			def.Probes{Readiness: &def.Probe{Http: &def.HttpProbe{Path: "/", Port: 8080}}},
+			true,
+		},
+		{
+			"tcp on a named port",
+			&api.Probes{Liveness: &api.Probe{Tcp: &api.TcpProbe{Port: "grpc"}, Period: "10s", FailureThreshold: 3}},
+			ports,
+			def.Probes{Liveness: &def.Probe{Tcp: &def.TcpProbe{Port: 9001}, PeriodSeconds: 10, FailureThreshold: 3}},
+			true,
+		},
+		{
+			"exec",
+			&api.Probes{Startup: &api.Probe{Exec: stringPtr(`pg_isready -U "postgres"`), InitialDelay: "5s"}},
+			nil,
+			def.Probes{Startup: &def.Probe{Exec: []string{"pg_isready", "-U", "postgres"}, InitialDelaySeconds: 5}},
+			true,
+		},
+		{
+			"readiness can pass more than once",
+			&api.Probes{Readiness: &api.Probe{Http: &api.HttpProbe{Path: "/ready"}, SuccessThreshold: 2}},
+			ports,
+			def.Probes{Readiness: &def.Probe{Http: &def.HttpProbe{Path: "/ready", Port: 8080}, SuccessThreshold: 2}},
+			true,
+		},
+		{"no handler", &api.Probes{Liveness: &api.Probe{}}, ports, def.Probes{}, false},
+		{"two handlers", &api.Probes{Liveness: &api.Probe{Http: &api.HttpProbe{}, Tcp: &api.TcpProbe{}}}, ports, def.Probes{}, false},
+		{"network probe without ports", &api.Probes{Liveness: &api.Probe{Tcp: &api.TcpProbe{}}}, nil, def.Probes{}, false},
+		{"unknown port", &api.Probes{Liveness: &api.Probe{Tcp: &api.TcpProbe{Port: "admin"}}}, ports, def.Probes{}, false},
+		{"empty exec", &api.Probes{Liveness: &api.Probe{Exec: stringPtr("")}}, nil, def.Probes{}, false},
+		{"unterminated exec", &api.Probes{Liveness: &api.Probe{Exec: stringPtr(`echo "hi`)}}, nil, def.Probes{}, false},
+		{"negative threshold", &api.Probes{Readiness: &api.Probe{Http: &api.HttpProbe{}, FailureThreshold: -1}}, ports, def.Probes{}, false},
+		{"liveness passing more than once", &api.Probes{Liveness: &api.Probe{Http: &api.HttpProbe{}, SuccessThreshold: 2}}, ports, def.Probes{}, false},
+		{"startup passing more than once", &api.Probes{Startup: &api.Probe{Http: &api.HttpProbe{}, SuccessThreshold: 2}}, ports, def.Probes{}, false},
+		{"zero period", &api.Probes{Readiness: &api.Probe{Http: &api.HttpProbe{}, Period: "0s"}}, ports, def.Probes{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probes, err := probesFromApi(tt.probes, tt.ports)
+			if !tt.valid {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", probes)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(probes, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, probes)
+			}
+		})
+	}
+}
+
+func TestProbeSeconds(t *testing.T) {
+	tests := []struct {
+		duration string
+		minimum  int32
+		seconds  int32
+		valid    bool
+	}{
+		{"", 1, 0, true},
+		{"0s", 0, 0, true},
+		{"10s", 1, 10, true},
+		{"2m", 1, 120, true},
+		{"0s", 1, 0, false},
+		{"1500ms", 0, 0, false},
+		{"-5s", 0, 0, false},
+		{"soon", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.duration, func(t *testing.T) {
+			seconds, err := probeSeconds(tt.duration, tt.minimum)
+			if !tt.valid {
+				if err == nil {
+					t.Errorf("expected an error, got %d", seconds)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if seconds != tt.seconds {
+				t.Errorf("expected %d, got %d", tt.seconds, seconds)
+			}
+		})
+	}
+}
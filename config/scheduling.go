@@ -0,0 +1,222 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/BSFishy/mora-manager/api"
+	"github.com/BSFishy/mora-manager/def"
+	"github.com/BSFishy/mora-manager/expr"
+	"github.com/BSFishy/mora-manager/point"
+	"github.com/BSFishy/mora-manager/value"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func validateTolerations(tolerations []api.Toleration) error {
+	for _, t := range tolerations {
+		switch t.Operator {
+		case "", "Equal":
+			if t.Key == nil {
+				return errors.New("tolerations with the Equal operator need a key")
+			}
+		case "Exists":
+			if t.Value != nil {
+				return errors.New("tolerations with the Exists operator can't have a value")
+			}
+		default:
+			return fmt.Errorf("invalid operator %s", t.Operator)
+		}
+
+		switch t.Effect {
+		case "", "NoSchedule", "PreferNoSchedule", "NoExecute":
+		default:
+			return fmt.Errorf("invalid effect %s", t.Effect)
+		}
+	}
+
+	return nil
+}
+
+// evaluateScheduling evaluates how many replicas the service runs and where
+// they're allowed to run
+func (s *ServiceConfig) evaluateScheduling(ctx context.Context, deps expr.EvaluationContext) (*def.Scheduling, []point.Point, error) {
+	configPoints := []point.Point{}
+	scheduling := def.Scheduling{}
+
+	if s.Replicas != nil {
+		replicas, replicasCfp, err := s.Replicas.Evaluate(ctx, deps)
+		if err != nil {
+			return nil, nil, fmt.Errorf("evaluating replicas: %w", err)
+		}
+
+		configPoints = append(configPoints, replicasCfp...)
+		if len(replicasCfp) == 0 {
+			if replicas.Kind() != value.Integer || replicas.Integer() < 0 {
+				return nil, nil, errors.New("invalid replicas property")
+			}
+
+			count := int32(replicas.Integer())
+			scheduling.Replicas = &count
+		}
+	}
+
+	if s.Resources != nil {
+		requests, requestsCfp, err := evaluateResourceList(ctx, deps, s.Resources.Requests)
+		if err != nil {
+			return nil, nil, fmt.Errorf("evaluating resource requests: %w", err)
+		}
+
+		limits, limitsCfp, err := evaluateResourceList(ctx, deps, s.Resources.Limits)
+		if err != nil {
+			return nil, nil, fmt.Errorf("evaluating resource limits: %w", err)
+		}
+
+		configPoints = append(configPoints, requestsCfp...)
+		configPoints = append(configPoints, limitsCfp...)
+
+		scheduling.Resources = def.Resources{
+			Requests: requests,
+			Limits:   limits,
+		}
+	}
+
+	if len(s.NodeSelector) > 0 {
+		scheduling.NodeSelector = map[string]string{}
+
+		// sorted so that config points come out in the same order every time
+		keys := make([]string, 0, len(s.NodeSelector))
+		for key := range s.NodeSelector {
+			keys = append(keys, key)
+		}
+
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			e := s.NodeSelector[key]
+			v, cfp, err := e.Evaluate(ctx, deps)
+			if err != nil {
+				return nil, nil, fmt.Errorf("evaluating node selector %s: %w", key, err)
+			}
+
+			configPoints = append(configPoints, cfp...)
+			if len(cfp) == 0 {
+				if v.Kind() != value.String {
+					return nil, nil, fmt.Errorf("invalid kind for node selector %s: %s", key, v.Kind())
+				}
+
+				scheduling.NodeSelector[key] = v.String()
+			}
+		}
+	}
+
+	for i, t := range s.Tolerations {
+		key, keyCfp, err := evaluateOptionalString(ctx, deps, t.Key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("evaluating toleration %d key: %w", i, err)
+		}
+
+		val, valCfp, err := evaluateOptionalString(ctx, deps, t.Value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("evaluating toleration %d value: %w", i, err)
+		}
+
+		configPoints = append(configPoints, keyCfp...)
+		configPoints = append(configPoints, valCfp...)
+
+		operator := t.Operator
+		if operator == "" {
+			operator = "Equal"
+		}
+
+		scheduling.Tolerations = append(scheduling.Tolerations, def.Toleration{
+			Key:      key,
+			Operator: operator,
+			Value:    val,
+			Effect:   t.Effect,
+		})
+	}
+
+	if len(configPoints) > 0 {
+		return nil, configPoints, nil
+	}
+
+	return &scheduling, nil, nil
+}
+
+func evaluateResourceList(ctx context.Context, deps expr.EvaluationContext, list *api.ResourceList) (def.ResourceList, []point.Point, error) {
+	if list == nil {
+		return def.ResourceList{}, nil, nil
+	}
+
+	cpu, cpuCfp, err := evaluateQuantity(ctx, deps, list.Cpu)
+	if err != nil {
+		return def.ResourceList{}, nil, fmt.Errorf("evaluating cpu: %w", err)
+	}
+
+	memory, memoryCfp, err := evaluateQuantity(ctx, deps, list.Memory)
+	if err != nil {
+		return def.ResourceList{}, nil, fmt.Errorf("evaluating memory: %w", err)
+	}
+
+	return def.ResourceList{
+		Cpu:    cpu,
+		Memory: memory,
+	}, append(cpuCfp, memoryCfp...), nil
+}
+
+// evaluateQuantity evaluates a kubernetes quantity. whole numbers can be given
+// as integers, everything else has to be a string like 500m
+func evaluateQuantity(ctx context.Context, deps expr.EvaluationContext, e *expr.Expression) (string, []point.Point, error) {
+	if e == nil {
+		return "", nil, nil
+	}
+
+	v, cfp, err := e.Evaluate(ctx, deps)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(cfp) > 0 {
+		return "", cfp, nil
+	}
+
+	var quantity string
+	switch v.Kind() {
+	case value.Integer:
+		quantity = strconv.Itoa(v.Integer())
+	case value.String:
+		quantity = v.String()
+	default:
+		return "", nil, fmt.Errorf("invalid kind for quantity: %s", v.Kind())
+	}
+
+	if _, err = resource.ParseQuantity(quantity); err != nil {
+		return "", nil, fmt.Errorf("invalid quantity %s: %w", quantity, err)
+	}
+
+	return quantity, nil, nil
+}
+
+func evaluateOptionalString(ctx context.Context, deps expr.EvaluationContext, e *expr.Expression) (string, []point.Point, error) {
+	if e == nil {
+		return "", nil, nil
+	}
+
+	v, cfp, err := e.Evaluate(ctx, deps)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(cfp) > 0 {
+		return "", cfp, nil
+	}
+
+	if v.Kind() != value.String {
+		return "", nil, fmt.Errorf("invalid kind: %s", v.Kind())
+	}
+
+	return v.String(), nil, nil
+}
@@ -4,14 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/BSFishy/mora-manager/api"
 	"github.com/BSFishy/mora-manager/core"
+	"github.com/BSFishy/mora-manager/def"
 	"github.com/BSFishy/mora-manager/expr"
+	"github.com/BSFishy/mora-manager/kube"
 	"github.com/BSFishy/mora-manager/point"
 	"github.com/BSFishy/mora-manager/state"
-	"github.com/BSFishy/mora-manager/util/shlex"
 	"github.com/BSFishy/mora-manager/value"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -19,16 +24,62 @@ type ServiceWingman struct {
 	Image expr.Expression
 }
 
+type ServiceExpose struct {
+	Host expr.Expression
+	Path *expr.Expression
+	Tls  *expr.Expression
+	Port def.Port
+}
+
 type ServiceConfig struct {
-	ModuleName  string
-	ServiceName string
-	Image       expr.Expression
-	Command     *expr.Expression
-	Env         []api.Env
+	ModuleName   string
+	ServiceName  string
+	Kind         def.Workload
+	Schedule     string
+	Image        expr.Expression
+	Command      *expr.Expression
+	Env          []api.Env
+	Ports        []def.Port
+	Expose       *ServiceExpose
+	Replicas     *expr.Expression
+	Resources    *api.Resources
+	NodeSelector map[string]expr.Expression
+	Tolerations  []api.Toleration
+	Probes       def.Probes
+	Volumes      []api.Volume
+	Files        []ServiceFile
+	// extra containers in the pod
+	InitContainers []ContainerConfig
+	Sidecars       []ContainerConfig
+	// how long the service gets to become ready. zero means the default
+	Timeout time.Duration
+	// services that need to be deployed before this one. this is the edge list
+	// of the dependency graph, which is used to deploy independent services in
+	// parallel
+	Requires []state.ServiceRef
 
 	Wingman *ServiceWingman
 }
 
+func (s *ServiceConfig) Ref() state.ServiceRef {
+	return state.ServiceRef{
+		Module:  s.ModuleName,
+		Service: s.ServiceName,
+	}
+}
+
+// DependenciesDeployed checks whether every service this one requires has
+// already been deployed
+func (s *ServiceConfig) DependenciesDeployed(st *state.State) bool {
+	for _, dep := range s.Requires {
+		if !st.IsDeployed(dep) {
+			return false
+		}
+	}
+
+	return true
+}
+
 type configFromModulesCtx struct {
 	client      kubernetes.Interface
 	registry    expr.FunctionRegistry
@@ -76,6 +127,9 @@ func ServiceConfigFromModules(ctx context.Context, deps interface {
 	graph := make(map[string][]string)
 	inDegree := make(map[string]int)
 
+	// declaration order, so that sorting is deterministic
+	var order []string
+
 	for _, module := range modules {
 		moduleDeps := &configFromModulesCtx{
 			client:      deps.GetClientset(),
@@ -85,6 +139,11 @@ func ServiceConfigFromModules(ctx context.Context, deps interface {
 			moduleName:  module.Name,
 		}
 
+		moduleFiles, err := moduleFilesFromApi(module.Files)
+		if err != nil {
+			return nil, fmt.Errorf("invalid files for module %s: %w", module.Name, err)
+		}
+
 		for _, service := range module.Services {
 			path := fmt.Sprintf("%s/%s", module.Name, service.Name)
 			requires, err := service.RequiredServices(ctx, moduleDeps)
@@ -92,6 +151,59 @@ func ServiceConfigFromModules(ctx context.Context, deps interface {
 				return nil, fmt.Errorf("getting required services: %w", err)
 			}
 
+			ports, err := portsFromApi(service.Ports)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ports for %s: %w", path, err)
+			}
+
+			expose, err := exposeFromApi(service.Expose, ports)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expose for %s: %w", path, err)
+			}
+
+			workload, err := workloadFromApi(service)
+			if err != nil {
+				return nil, fmt.Errorf("invalid kind for %s: %w", path, err)
+			}
+
+			if err = validateVolumes(workload, service.Volumes); err != nil {
+				return nil, fmt.Errorf("invalid volumes for %s: %w", path, err)
+			}
+
+			files, err := filesFromApi(service.Files, service.Volumes, moduleFiles)
+			if err != nil {
+				return nil, fmt.Errorf("invalid files for %s: %w", path, err)
+			}
+
+			probes, err := probesFromApi(service.Probes, ports)
+			if err != nil {
+				return nil, fmt.Errorf("invalid probes for %s: %w", path, err)
+			}
+
+			if err = validateTolerations(service.Tolerations); err != nil {
+				return nil, fmt.Errorf("invalid tolerations for %s: %w", path, err)
+			}
+
+			mainContainer := kube.MainContainerName(module.Name, service.Name)
+			containerNames := map[string]bool{}
+			initContainers, err := containersFromApi(service.InitContainers, mainContainer, containerNames)
+			if err != nil {
+				return nil, fmt.Errorf("invalid init containers for %s: %w", path, err)
+			}
+
+			sidecars, err := containersFromApi(service.Sidecars, mainContainer, containerNames)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sidecars for %s: %w", path, err)
+			}
+
+			var timeout time.Duration
+			if service.Timeout != "" {
+				timeout, err = time.ParseDuration(service.Timeout)
+				if err != nil || timeout <= 0 {
+					return nil, fmt.Errorf("invalid timeout for %s: %s", path, service.Timeout)
+				}
+			}
+
 			var wingman *ServiceWingman
 			if service.Wingman != nil {
 				wingman = &ServiceWingman{
@@ -100,16 +212,33 @@ func ServiceConfigFromModules(ctx context.Context, deps interface {
 			}
 
 			services[path] = ServiceConfig{
-				ModuleName:  module.Name,
-				ServiceName: service.Name,
-				Image:       service.Image,
-				Command:     service.Command,
-				Env:         service.Env,
-				Wingman:     wingman,
+				ModuleName:     module.Name,
+				ServiceName:    service.Name,
+				Kind:           workload,
+				Schedule:       service.Schedule,
+				Image:          service.Image,
+				Command:        service.Command,
+				Env:            service.Env,
+				Ports:          ports,
+				Expose:         expose,
+				Replicas:       service.Replicas,
+				Resources:      service.Resources,
+				NodeSelector:   service.NodeSelector,
+				Tolerations:    service.Tolerations,
+				Probes:         probes,
+				Volumes:        service.Volumes,
+				Files:          files,
+				InitContainers: initContainers,
+				Sidecars:       sidecars,
+				Timeout:        timeout,
+				Requires:       requires,
+				Wingman:        wingman,
 			}
 
+			order = append(order, path)
+
 			for _, dep := range requires {
-				depPath := fmt.Sprintf("%s/%s", dep.Module, dep.Service)
+				depPath := dep.String()
 				graph[depPath] = append(graph[depPath], path)
 				inDegree[path]++
 			}
@@ -120,9 +249,23 @@ func ServiceConfigFromModules(ctx context.Context, deps interface {
 		}
 	}
 
+	// this has to happen after everything is collected, since services can
+	// require services from modules that are declared later
+	for _, path := range order {
+		service := services[path]
+		for _, dep := range service.Requires {
+			if _, ok := services[dep.String()]; !ok {
+				return nil, &UnknownServiceError{
+					Service:  service.Ref(),
+					Requires: dep,
+				}
+			}
+		}
+	}
+
 	var queue []string
-	for path, deg := range inDegree {
-		if deg == 0 {
+	for _, path := range order {
+		if inDegree[path] == 0 {
 			queue = append(queue, path)
 		}
 	}
@@ -141,9 +284,58 @@ func ServiceConfigFromModules(ctx context.Context, deps interface {
 		}
 	}
 
+	if len(result) < len(services) {
+		return nil, findCycle(services, order, inDegree)
+	}
+
 	return result, nil
 }
 
+// findCycle walks the requires edges of the services that couldn't be sorted.
+// every one of them is either part of a cycle or requires something that is, so
+// following the edges from any of them is guaranteed to run into one
+func findCycle(services map[string]ServiceConfig, order []string, inDegree map[string]int) error {
+	var start string
+	for _, path := range order {
+		if inDegree[path] > 0 {
+			start = path
+			break
+		}
+	}
+
+	var path []string
+	visited := map[string]int{}
+	cur := start
+	for {
+		if i, ok := visited[cur]; ok {
+			path = append(path[i:], cur)
+			break
+		}
+
+		visited[cur] = len(path)
+		path = append(path, cur)
+
+		// pick any dependency that is also stuck
+		service := services[cur]
+		for _, dep := range service.Requires {
+			if inDegree[dep.String()] > 0 {
+				cur = dep.String()
+				break
+			}
+		}
+	}
+
+	refs := make([]state.ServiceRef, len(path))
+	for i, p := range path {
+		service := services[p]
+		refs[i] = service.Ref()
+	}
+
+	return &CycleError{
+		Path: refs,
+	}
+}
+
 func (s *ServiceConfig) EvaluateWingman(ctx context.Context, deps expr.EvaluationContext) (*WingmanDefinition, []point.Point, error) {
 	if s.Wingman == nil {
 		return nil, nil, nil
@@ -174,57 +366,230 @@ func (s *ServiceConfig) EvaluateWingman(ctx context.Context, deps expr.Evaluatio
 func (s *ServiceConfig) Evaluate(ctx context.Context, deps expr.EvaluationContext) (*ServiceDefinition, []point.Point, error) {
 	configPoints := []point.Point{}
 
-	image, imageCfp, err := s.Image.Evaluate(ctx, deps)
+	main := ContainerConfig{
+		Image:   s.Image,
+		Command: s.Command,
+		Env:     s.Env,
+	}
+
+	container, containerCfp, err := main.Evaluate(ctx, deps)
 	if err != nil {
-		return nil, nil, fmt.Errorf("evaluating image: %w", err)
+		return nil, nil, err
 	}
 
-	if image.Kind() != value.String {
-		return nil, nil, errors.New("invalid image property")
+	configPoints = append(configPoints, containerCfp...)
+
+	initContainers, initCfp, err := evaluateContainers(ctx, deps, s.InitContainers)
+	if err != nil {
+		return nil, nil, fmt.Errorf("evaluating init containers: %w", err)
 	}
 
-	configPoints = append(configPoints, imageCfp...)
+	configPoints = append(configPoints, initCfp...)
+
+	sidecars, sidecarsCfp, err := evaluateContainers(ctx, deps, s.Sidecars)
+	if err != nil {
+		return nil, nil, fmt.Errorf("evaluating sidecars: %w", err)
+	}
 
-	var command []string
-	if s.Command != nil {
-		cmd, cmdCfp, err := s.Command.Evaluate(ctx, deps)
+	configPoints = append(configPoints, sidecarsCfp...)
+
+	var expose *ExposeDefinition
+	if s.Expose != nil {
+		var exposeCfp []point.Point
+		expose, exposeCfp, err = s.Expose.Evaluate(ctx, deps)
 		if err != nil {
-			return nil, nil, fmt.Errorf("evaluating command: %w", err)
+			return nil, nil, fmt.Errorf("evaluating expose: %w", err)
+		}
+
+		configPoints = append(configPoints, exposeCfp...)
+	}
+
+	scheduling, schedulingCfp, err := s.evaluateScheduling(ctx, deps)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	configPoints = append(configPoints, schedulingCfp...)
+
+	volumes, volumesCfp, err := s.evaluateVolumes(ctx, deps)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	configPoints = append(configPoints, volumesCfp...)
+
+	files, checksum, filesCfp, err := s.evaluateFiles(ctx, deps)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	configPoints = append(configPoints, filesCfp...)
+
+	if len(configPoints) > 0 {
+		return nil, configPoints, nil
+	}
+
+	return &ServiceDefinition{
+		Kind:       s.Kind,
+		Schedule:   s.Schedule,
+		Image:      container.Image,
+		Command:    container.Command,
+		Env:        container.Env,
+		Ports:      s.Ports,
+		Expose:     expose,
+		Scheduling: *scheduling,
+		Probes:     s.Probes,
+		Volumes:    volumes,
+		Files:      files,
+		Checksum:   checksum,
+
+		InitContainers: initContainers,
+		Sidecars:       sidecars,
+	}, configPoints, nil
+}
+
+// portsFromApi validates the ports of a service and fills in the defaults
+func portsFromApi(apiPorts []api.Port) ([]def.Port, error) {
+	ports := make([]def.Port, len(apiPorts))
+	names := map[string]bool{}
+	for i, p := range apiPorts {
+		if len(apiPorts) > 1 && p.Name == "" {
+			return nil, errors.New("every port needs a name when there is more than one")
+		}
+
+		if p.Name != "" {
+			if errs := validation.IsValidPortName(p.Name); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid port name %s: %s", p.Name, strings.Join(errs, ", "))
+			}
+
+			if names[p.Name] {
+				return nil, fmt.Errorf("duplicate port name %s", p.Name)
+			}
+
+			names[p.Name] = true
 		}
 
-		if len(cmdCfp) == 0 && cmd.Kind() != value.String {
-			return nil, nil, errors.New("invalid command property")
+		if errs := validation.IsValidPortNum(int(p.Port)); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid port %d: %s", p.Port, strings.Join(errs, ", "))
 		}
 
-		configPoints = append(configPoints, cmdCfp...)
-		cmdString := cmd.String()
+		targetPort := p.Port
+		if p.TargetPort != nil {
+			targetPort = *p.TargetPort
+			if errs := validation.IsValidPortNum(int(targetPort)); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid target port %d: %s", targetPort, strings.Join(errs, ", "))
+			}
+		}
 
-		command, err = shlex.Split(cmdString)
-		if err != nil {
-			return nil, nil, fmt.Errorf("splitting command: %w", err)
+		protocol := p.Protocol
+		switch protocol {
+		case "":
+			protocol = "TCP"
+		case "TCP", "UDP", "SCTP":
+		default:
+			return nil, fmt.Errorf("invalid protocol %s", protocol)
+		}
+
+		ports[i] = def.Port{
+			Name:       p.Name,
+			Port:       p.Port,
+			TargetPort: targetPort,
+			Protocol:   protocol,
 		}
 	}
 
-	envs := []MaterializedEnv{}
-	for _, e := range s.Env {
-		ev, envCfp, err := e.Value.Evaluate(ctx, deps)
+	return ports, nil
+}
+
+// findPort finds a port by name, defaulting to the first one
+func findPort(ports []def.Port, name string) (def.Port, error) {
+	if name == "" {
+		return ports[0], nil
+	}
+
+	idx := slices.IndexFunc(ports, func(p def.Port) bool {
+		return p.Name == name
+	})
+
+	if idx < 0 {
+		return def.Port{}, fmt.Errorf("unknown port %s", name)
+	}
+
+	return ports[idx], nil
+}
+
+// exposeFromApi picks the port that an exposed service routes traffic to
+func exposeFromApi(apiExpose *api.Expose, ports []def.Port) (*ServiceExpose, error) {
+	if apiExpose == nil {
+		return nil, nil
+	}
+
+	if len(ports) == 0 {
+		return nil, errors.New("exposed services need a port")
+	}
+
+	port, err := findPort(ports, apiExpose.Port)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServiceExpose{
+		Host: apiExpose.Host,
+		Path: apiExpose.Path,
+		Tls:  apiExpose.Tls,
+		Port: port,
+	}, nil
+}
+
+func (e *ServiceExpose) Evaluate(ctx context.Context, deps expr.EvaluationContext) (*ExposeDefinition, []point.Point, error) {
+	configPoints := []point.Point{}
+
+	host, hostCfp, err := e.Host.Evaluate(ctx, deps)
+	if err != nil {
+		return nil, nil, fmt.Errorf("evaluating host: %w", err)
+	}
+
+	configPoints = append(configPoints, hostCfp...)
+	if len(hostCfp) == 0 && host.Kind() != value.String {
+		return nil, nil, errors.New("invalid host property")
+	}
+
+	path := "/"
+	if e.Path != nil {
+		pathValue, pathCfp, err := e.Path.Evaluate(ctx, deps)
 		if err != nil {
-			return nil, nil, fmt.Errorf("evaluating env %s: %w", e.Name, err)
+			return nil, nil, fmt.Errorf("evaluating path: %w", err)
 		}
 
-		configPoints = append(configPoints, envCfp...)
+		configPoints = append(configPoints, pathCfp...)
+		if len(pathCfp) == 0 {
+			if pathValue.Kind() != value.String || !strings.HasPrefix(pathValue.String(), "/") {
+				return nil, nil, errors.New("invalid path property")
+			}
 
-		if len(envCfp) == 0 {
-			switch ev.Kind() {
+			path = pathValue.String()
+		}
+	}
+
+	var tlsSecret string
+	if e.Tls != nil {
+		tls, tlsCfp, err := e.Tls.Evaluate(ctx, deps)
+		if err != nil {
+			return nil, nil, fmt.Errorf("evaluating tls: %w", err)
+		}
+
+		configPoints = append(configPoints, tlsCfp...)
+		if len(tlsCfp) == 0 {
+			switch tls.Kind() {
 			case value.String:
-				fallthrough
+				tlsSecret = tls.String()
 			case value.Secret:
-				envs = append(envs, MaterializedEnv{
-					Name:  e.Name,
-					Value: ev,
-				})
+				// secret configs only hold a single value, while ingress controllers
+				// need a kubernetes.io/tls secret with a certificate and a key. they
+				// quietly fall back to their default certificate otherwise
+				return nil, nil, errors.New("tls has to name a kubernetes.io/tls secret, not a secret config")
 			default:
-				return nil, nil, fmt.Errorf("invalid kind for env %s: %s", e.Name, ev.Kind())
+				return nil, nil, fmt.Errorf("invalid kind for tls: %s", tls.Kind())
 			}
 		}
 	}
@@ -233,9 +598,10 @@ func (s *ServiceConfig) Evaluate(ctx context.Context, deps expr.EvaluationContex
 		return nil, configPoints, nil
 	}
 
-	return &ServiceDefinition{
-		Image:   image.String(),
-		Command: command,
-		Env:     envs,
-	}, configPoints, nil
+	return &ExposeDefinition{
+		Host:      host.String(),
+		Path:      path,
+		TlsSecret: tlsSecret,
+		Port:      e.Port.Port,
+	}, nil, nil
 }
@@ -0,0 +1,447 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/BSFishy/mora-manager/api"
+	"github.com/BSFishy/mora-manager/def"
+	"github.com/BSFishy/mora-manager/expr"
+	"github.com/BSFishy/mora-manager/kube"
+	"github.com/BSFishy/mora-manager/point"
+	"github.com/BSFishy/mora-manager/state"
+	"github.com/BSFishy/mora-manager/value"
+	"k8s.io/client-go/kubernetes"
+)
+
+// testContext evaluates configs that only use plain values and service
+// references, so there's no cluster or wingman behind it
+type testContext struct {
+	moduleName  string
+	serviceName string
+}
+
+func (t *testContext) GetClientset() kubernetes.Interface {
+	return nil
+}
+
+func (t *testContext) GetFunctionRegistry() expr.FunctionRegistry {
+	return &testRegistry{}
+}
+
+// testRegistry only knows the service function
+type testRegistry struct{}
+
+func (r *testRegistry) Evaluate(ctx context.Context, deps expr.EvaluationContext, name string, args expr.Args) (value.Value, []point.Point, error) {
+	if name != "service" {
+		return nil, nil, fmt.Errorf("invalid function: %s", name)
+	}
+
+	moduleName, err := args.Identifier(ctx, deps, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serviceName, err := args.Identifier(ctx, deps, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return value.NewServiceReference(moduleName, serviceName), nil, nil
+}
+
+func (t *testContext) GetUser() string {
+	return "user"
+}
+
+func (t *testContext) GetEnvironment() string {
+	return "env"
+}
+
+func (t *testContext) GetState() *state.State {
+	return &state.State{}
+}
+
+func (t *testContext) GetConfig() expr.Config {
+	return &Config{}
+}
+
+func (t *testContext) GetModuleName() string {
+	return t.moduleName
+}
+
+func (t *testContext) GetServiceName() string {
+	return t.serviceName
+}
+
+// parseModules reads modules the way they come in through the api
+func parseModules(t *testing.T, raw string) []api.Module {
+	t.Helper()
+
+	var modules []api.Module
+	if err := json.Unmarshal([]byte(raw), &modules); err != nil {
+		t.Fatalf("parsing modules: %s", err)
+	}
+
+	return modules
+}
+
+// materialize takes a single service from the module file all the way to the
+// resources that get deployed for it
+func materialize(t *testing.T, raw string) (*ServiceConfig, *kube.MaterializedService) {
+	t.Helper()
+
+	ctx := context.Background()
+	services, err := ServiceConfigFromModules(ctx, &testContext{}, parseModules(t, raw))
+	if err != nil {
+		t.Fatalf("getting service configs: %s", err)
+	}
+
+	if len(services) != 1 {
+		t.Fatalf("expected 1 service, got %d", len(services))
+	}
+
+	service := services[0]
+	deps := &testContext{moduleName: service.ModuleName, serviceName: service.ServiceName}
+
+	definition, cfp, err := service.Evaluate(ctx, deps)
+	if err != nil {
+		t.Fatalf("evaluating service: %s", err)
+	}
+
+	if len(cfp) > 0 {
+		t.Fatalf("unexpected config points: %v", cfp)
+	}
+
+	return &service, definition.Materialize(deps)
+}
+
+func TestStatefulServiceMaterializesStatefulSet(t *testing.T) {
+	_, service := materialize(t, `[{
+		"name": "db",
+		"services": [{
+			"name": "postgres",
+			"kind": "stateful",
+			"image": {"atom": {"string": "postgres:17"}},
+			"ports": [{"port": 5432}],
+			"volumes": [{"name": "data", "path": "/var/lib/postgresql", "size": {"atom": {"string": "1Gi"}}}]
+		}]
+	}]`)
+
+	if len(service.Deployments) != 0 {
+		t.Errorf("expected no deployments, got %d", len(service.Deployments))
+	}
+
+	if len(service.StatefulSets) != 1 {
+		t.Fatalf("expected 1 stateful set, got %d", len(service.StatefulSets))
+	}
+
+	if len(service.Services) != 1 {
+		t.Fatalf("expected 1 service, got %d", len(service.Services))
+	}
+
+	if name := service.Services[0].Name(); name != "db-postgres" {
+		t.Errorf("expected the governing service to be db-postgres, got %s", name)
+	}
+}
+
+func TestJobServiceMaterializesJob(t *testing.T) {
+	_, service := materialize(t, `[{
+		"name": "app",
+		"services": [{
+			"name": "migrate",
+			"kind": "job",
+			"image": {"atom": {"string": "app:latest"}}
+		}]
+	}]`)
+
+	if len(service.Deployments) != 0 {
+		t.Errorf("expected no deployments, got %d", len(service.Deployments))
+	}
+
+	if len(service.Jobs) != 1 {
+		t.Errorf("expected 1 job, got %d", len(service.Jobs))
+	}
+}
+
+func TestCronJobServiceMaterializesCronJob(t *testing.T) {
+	_, service := materialize(t, `[{
+		"name": "app",
+		"services": [{
+			"name": "cleanup",
+			"kind": "cronjob",
+			"schedule": "0 3 * * *",
+			"image": {"atom": {"string": "app:latest"}}
+		}]
+	}]`)
+
+	if len(service.Deployments) != 0 {
+		t.Errorf("expected no deployments, got %d", len(service.Deployments))
+	}
+
+	if len(service.CronJobs) != 1 {
+		t.Errorf("expected 1 cron job, got %d", len(service.CronJobs))
+	}
+}
+
+// deployments store their config and read it back when they're resumed or
+// rolled back to
+func TestServiceConfigSurvivesStorage(t *testing.T) {
+	config, _ := materialize(t, `[{
+		"name": "app",
+		"services": [{
+			"name": "cleanup",
+			"kind": "cronjob",
+			"schedule": "0 3 * * *",
+			"image": {"atom": {"string": "app:latest"}}
+		}]
+	}]`)
+
+	raw, err := json.Marshal(Config{Services: []ServiceConfig{*config}})
+	if err != nil {
+		t.Fatalf("encoding config: %s", err)
+	}
+
+	var stored Config
+	if err = json.Unmarshal(raw, &stored); err != nil {
+		t.Fatalf("decoding config: %s", err)
+	}
+
+	service := stored.Services[0]
+	if service.Kind != def.WorkloadCronJob {
+		t.Errorf("expected kind %s, got %q", def.WorkloadCronJob, service.Kind)
+	}
+
+	if service.Schedule != "0 3 * * *" {
+		t.Errorf("expected schedule 0 3 * * *, got %q", service.Schedule)
+	}
+}
+
+// testService is a service in a module file that requires the given services,
+// written as module/service
+func testService(name string, requires ...string) string {
+	exprs := make([]string, len(requires))
+	for i, ref := range requires {
+		moduleName, serviceName, _ := strings.Cut(ref, "/")
+		exprs[i] = fmt.Sprintf(`{"list": [{"atom": {"identifier": "service"}}, {"atom": {"identifier": %q}}, {"atom": {"identifier": %q}}]}`, moduleName, serviceName)
+	}
+
+	return fmt.Sprintf(`{"name": %q, "image": {"atom": {"string": "app:latest"}}, "requires": [%s]}`, name, strings.Join(exprs, ", "))
+}
+
+func ref(path string) state.ServiceRef {
+	moduleName, serviceName, _ := strings.Cut(path, "/")
+	return state.ServiceRef{Module: moduleName, Service: serviceName}
+}
+
+func TestServiceConfigFromModulesSortsDependencies(t *testing.T) {
+	tests := []struct {
+		name    string
+		modules string
+		order   []string
+		unknown *UnknownServiceError
+		cycle   []string
+	}{
+		{
+			name:    "independent",
+			modules: `[{"name": "app", "services": [` + testService("web") + `, ` + testService("worker") + `]}]`,
+			order:   []string{"app/web", "app/worker"},
+		},
+		{
+			name: "required from a later module",
+			modules: `[
+				{"name": "app", "services": [` + testService("web", "db/postgres") + `]},
+				{"name": "db", "services": [` + testService("postgres") + `]}
+			]`,
+			order: []string{"db/postgres", "app/web"},
+		},
+		{
+			name:    "unknown service",
+			modules: `[{"name": "app", "services": [` + testService("web", "db/redis") + `]}]`,
+			unknown: &UnknownServiceError{Service: ref("app/web"), Requires: ref("db/redis")},
+		},
+		{
+			name:    "requires itself",
+			modules: `[{"name": "app", "services": [` + testService("web", "app/web") + `]}]`,
+			cycle:   []string{"app/web", "app/web"},
+		},
+		{
+			name:    "cycle",
+			modules: `[{"name": "app", "services": [` + testService("a", "app/b") + `, ` + testService("b", "app/a") + `]}]`,
+			cycle:   []string{"app/a", "app/b", "app/a"},
+		},
+		{
+			name:    "stuck behind a cycle",
+			modules: `[{"name": "app", "services": [` + testService("c", "app/a") + `, ` + testService("a", "app/b") + `, ` + testService("b", "app/a") + `]}]`,
+			cycle:   []string{"app/a", "app/b", "app/a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services, err := ServiceConfigFromModules(context.Background(), &testContext{}, parseModules(t, tt.modules))
+
+			if tt.unknown != nil {
+				var unknownErr *UnknownServiceError
+				if !errors.As(err, &unknownErr) {
+					t.Fatalf("expected an unknown service error, got %v", err)
+				}
+
+				if *unknownErr != *tt.unknown {
+					t.Errorf("expected %s, got %s", tt.unknown, unknownErr)
+				}
+
+				return
+			}
+
+			if tt.cycle != nil {
+				var cycleErr *CycleError
+				if !errors.As(err, &cycleErr) {
+					t.Fatalf("expected a cycle error, got %v", err)
+				}
+
+				cycle := make([]state.ServiceRef, len(tt.cycle))
+				for i, path := range tt.cycle {
+					cycle[i] = ref(path)
+				}
+
+				if !slices.Equal(cycleErr.Path, cycle) {
+					t.Errorf("expected %s, got %s", &CycleError{Path: cycle}, cycleErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			order := make([]string, len(services))
+			for i, service := range services {
+				order[i] = service.Ref().String()
+			}
+
+			if !slices.Equal(order, tt.order) {
+				t.Errorf("expected %v, got %v", tt.order, order)
+			}
+		})
+	}
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func TestPortsFromApi(t *testing.T) {
+	tests := []struct {
+		name  string
+		ports []api.Port
+		want  []def.Port
+		valid bool
+	}{
+		{"none", nil, []def.Port{}, true},
+		{
+			"defaults",
+			[]api.Port{{Port: 8080}},
+			[]def.Port{{Port: 8080, TargetPort: 8080, Protocol: "TCP"}},
+			true,
+		},
+		{
+			"target port and protocol",
+			[]api.Port{{Name: "dns", Port: 53, TargetPort: int32Ptr(5353), Protocol: "UDP"}},
+			[]def.Port{{Name: "dns", Port: 53, TargetPort: 5353, Protocol: "UDP"}},
+			true,
+		},
+		{
+			"named ports",
+			[]api.Port{{Name: "http", Port: 80}, {Name: "metrics", Port: 9090}},
+			[]def.Port{{Name: "http", Port: 80, TargetPort: 80, Protocol: "TCP"}, {Name: "metrics", Port: 9090, TargetPort: 9090, Protocol: "TCP"}},
+			true,
+		},
+		{"unnamed with more than one", []api.Port{{Name: "http", Port: 80}, {Port: 9090}}, nil, false},
+		{"duplicate name", []api.Port{{Name: "http", Port: 80}, {Name: "http", Port: 8080}}, nil, false},
+		{"invalid name", []api.Port{{Name: "Not_A_Port", Port: 80}}, nil, false},
+		{"zero", []api.Port{{Port: 0}}, nil, false},
+		{"too large", []api.Port{{Port: 65536}}, nil, false},
+		{"invalid target port", []api.Port{{Port: 80, TargetPort: int32Ptr(0)}}, nil, false},
+		{"invalid protocol", []api.Port{{Port: 80, Protocol: "HTTP"}}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ports, err := portsFromApi(tt.ports)
+			if !tt.valid {
+				if err == nil {
+					t.Errorf("expected an error, got %v", ports)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !slices.Equal(ports, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, ports)
+			}
+		})
+	}
+}
+
+func TestExposeFromApi(t *testing.T) {
+	http := def.Port{Name: "http", Port: 80, TargetPort: 8080, Protocol: "TCP"}
+	metrics := def.Port{Name: "metrics", Port: 9090, TargetPort: 9090, Protocol: "TCP"}
+
+	tests := []struct {
+		name   string
+		expose *api.Expose
+		ports  []def.Port
+		port   *def.Port
+		valid  bool
+	}{
+		{"not exposed", nil, []def.Port{http}, nil, true},
+		{"first port", &api.Expose{}, []def.Port{http, metrics}, &http, true},
+		{"named port", &api.Expose{Port: "metrics"}, []def.Port{http, metrics}, &metrics, true},
+		{"unknown port", &api.Expose{Port: "admin"}, []def.Port{http, metrics}, nil, false},
+		{"no ports", &api.Expose{}, []def.Port{}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expose, err := exposeFromApi(tt.expose, tt.ports)
+			if !tt.valid {
+				if err == nil {
+					t.Errorf("expected an error, got %v", expose)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if tt.port == nil {
+				if expose != nil {
+					t.Errorf("expected not to be exposed, got %v", expose)
+				}
+
+				return
+			}
+
+			if expose == nil {
+				t.Fatal("expected to be exposed")
+			}
+
+			if expose.Port != *tt.port {
+				t.Errorf("expected port %v, got %v", *tt.port, expose.Port)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/BSFishy/mora-manager/api"
+	"github.com/BSFishy/mora-manager/def"
+	"github.com/BSFishy/mora-manager/expr"
+	"github.com/BSFishy/mora-manager/point"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func validateVolumes(workload def.Workload, volumes []api.Volume) error {
+	if len(volumes) > 0 && workload != def.WorkloadStateful {
+		return errors.New("only stateful services can have volumes")
+	}
+
+	names := map[string]bool{}
+	paths := map[string]bool{}
+	for _, v := range volumes {
+		if errs := validation.IsDNS1123Label(v.Name); len(errs) > 0 {
+			return fmt.Errorf("invalid volume name %s: %s", v.Name, strings.Join(errs, ", "))
+		}
+
+		// mora uses these for its own volumes
+		if strings.HasPrefix(v.Name, "mora-") {
+			return fmt.Errorf("volume names can't start with mora-: %s", v.Name)
+		}
+
+		if names[v.Name] {
+			return fmt.Errorf("duplicate volume name %s", v.Name)
+		}
+
+		names[v.Name] = true
+
+		if !path.IsAbs(v.Path) {
+			return fmt.Errorf("volume %s needs an absolute path", v.Name)
+		}
+
+		if paths[path.Clean(v.Path)] {
+			return fmt.Errorf("duplicate volume path %s", v.Path)
+		}
+
+		paths[path.Clean(v.Path)] = true
+	}
+
+	return nil
+}
+
+func (s *ServiceConfig) evaluateVolumes(ctx context.Context, deps expr.EvaluationContext) ([]def.Volume, []point.Point, error) {
+	configPoints := []point.Point{}
+	volumes := make([]def.Volume, len(s.Volumes))
+
+	for i, v := range s.Volumes {
+		size, sizeCfp, err := evaluateQuantity(ctx, deps, &v.Size)
+		if err != nil {
+			return nil, nil, fmt.Errorf("evaluating volume %s size: %w", v.Name, err)
+		}
+
+		storageClass, storageClassCfp, err := evaluateOptionalString(ctx, deps, v.StorageClass)
+		if err != nil {
+			return nil, nil, fmt.Errorf("evaluating volume %s storage class: %w", v.Name, err)
+		}
+
+		configPoints = append(configPoints, sizeCfp...)
+		configPoints = append(configPoints, storageClassCfp...)
+
+		volumes[i] = def.Volume{
+			Name:         v.Name,
+			Path:         v.Path,
+			Size:         size,
+			StorageClass: storageClass,
+		}
+	}
+
+	if len(configPoints) > 0 {
+		return nil, configPoints, nil
+	}
+
+	return volumes, nil, nil
+}
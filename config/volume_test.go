@@ -0,0 +1,38 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/BSFishy/mora-manager/api"
+	"github.com/BSFishy/mora-manager/def"
+)
+
+func TestValidateVolumes(t *testing.T) {
+	tests := []struct {
+		name     string
+		workload def.Workload
+		volumes  []api.Volume
+		valid    bool
+	}{
+		{"no volumes", def.WorkloadDeployment, nil, true},
+		{"stateful", def.WorkloadStateful, []api.Volume{{Name: "data", Path: "/data"}}, true},
+		{"deployment with volumes", def.WorkloadDeployment, []api.Volume{{Name: "data", Path: "/data"}}, false},
+		{"job with volumes", def.WorkloadJob, []api.Volume{{Name: "data", Path: "/data"}}, false},
+		{"invalid name", def.WorkloadStateful, []api.Volume{{Name: "Data", Path: "/data"}}, false},
+		{"reserved name", def.WorkloadStateful, []api.Volume{{Name: "mora-data", Path: "/data"}}, false},
+		{"relative path", def.WorkloadStateful, []api.Volume{{Name: "data", Path: "data"}}, false},
+		{"duplicate name", def.WorkloadStateful, []api.Volume{{Name: "data", Path: "/a"}, {Name: "data", Path: "/b"}}, false},
+		{"duplicate path", def.WorkloadStateful, []api.Volume{{Name: "a", Path: "/data"}, {Name: "b", Path: "/data/"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateVolumes(tt.workload, tt.volumes)
+			if tt.valid && err != nil {
+				t.Errorf("expected valid, got %s", err)
+			} else if !tt.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/BSFishy/mora-manager/api"
+	"github.com/BSFishy/mora-manager/def"
+)
+
+// workloadFromApi figures out what kind of workload runs the service and makes
+// sure the service only uses what that kind supports
+func workloadFromApi(service api.Service) (def.Workload, error) {
+	workload := def.Workload(service.Kind)
+	switch workload {
+	case "":
+		workload = def.WorkloadDeployment
+	case def.WorkloadDeployment, def.WorkloadStateful, def.WorkloadJob, def.WorkloadCronJob:
+	default:
+		return "", fmt.Errorf("unknown kind %s", service.Kind)
+	}
+
+	if workload == def.WorkloadCronJob {
+		if !validSchedule(service.Schedule) {
+			return "", fmt.Errorf("invalid schedule %q", service.Schedule)
+		}
+	} else if service.Schedule != "" {
+		return "", errors.New("only cronjobs can have a schedule")
+	}
+
+	if workload == def.WorkloadJob || workload == def.WorkloadCronJob {
+		if service.Replicas != nil {
+			return "", fmt.Errorf("%ss can't have replicas", workload)
+		}
+
+		if service.Expose != nil {
+			return "", fmt.Errorf("%ss can't be exposed", workload)
+		}
+	}
+
+	return workload, nil
+}
+
+// validSchedule does a rough check of a cron schedule. kubernetes does the
+// real validation, but catching obvious mistakes here means they show up
+// before anything is deployed
+func validSchedule(schedule string) bool {
+	if strings.HasPrefix(schedule, "@") {
+		return len(strings.Fields(schedule)) == 1
+	}
+
+	return len(strings.Fields(schedule)) == 5
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/BSFishy/mora-manager/api"
+	"github.com/BSFishy/mora-manager/def"
+	"github.com/BSFishy/mora-manager/expr"
+)
+
+func TestWorkloadFromApi(t *testing.T) {
+	tests := []struct {
+		name     string
+		service  api.Service
+		workload def.Workload
+		valid    bool
+	}{
+		{"default", api.Service{}, def.WorkloadDeployment, true},
+		{"deployment", api.Service{Kind: "deployment"}, def.WorkloadDeployment, true},
+		{"stateful", api.Service{Kind: "stateful"}, def.WorkloadStateful, true},
+		{"job", api.Service{Kind: "job"}, def.WorkloadJob, true},
+		{"cronjob", api.Service{Kind: "cronjob", Schedule: "0 * * * *"}, def.WorkloadCronJob, true},
+		{"unknown", api.Service{Kind: "daemonset"}, "", false},
+		{"cronjob without schedule", api.Service{Kind: "cronjob"}, "", false},
+		{"schedule on a deployment", api.Service{Schedule: "0 * * * *"}, "", false},
+		{"job with replicas", api.Service{Kind: "job", Replicas: &expr.Expression{}}, "", false},
+		{"cronjob exposed", api.Service{Kind: "cronjob", Schedule: "@daily", Expose: &api.Expose{}}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workload, err := workloadFromApi(tt.service)
+			if !tt.valid {
+				if err == nil {
+					t.Errorf("expected an error, got %s", workload)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if workload != tt.workload {
+				t.Errorf("expected %s, got %s", tt.workload, workload)
+			}
+		})
+	}
+}
+
+func TestValidSchedule(t *testing.T) {
+	tests := []struct {
+		schedule string
+		valid    bool
+	}{
+		{"0 * * * *", true},
+		{"*/5 1-3 * * MON-FRI", true},
+		{"@hourly", true},
+		{"", false},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"@every 5m", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.schedule, func(t *testing.T) {
+			if valid := validSchedule(tt.schedule); valid != tt.valid {
+				t.Errorf("expected %t, got %t", tt.valid, valid)
+			}
+		})
+	}
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/BSFishy/mora-manager/config"
 	"github.com/BSFishy/mora-manager/core"
+	"github.com/BSFishy/mora-manager/def"
 	"github.com/BSFishy/mora-manager/expr"
+	"github.com/BSFishy/mora-manager/kube"
 	"github.com/BSFishy/mora-manager/model"
 	"github.com/BSFishy/mora-manager/state"
 	"github.com/BSFishy/mora-manager/wingman"
@@ -61,6 +66,7 @@ var (
 	_ wingman.HasManager     = (*runwayContext)(nil)
 	_ core.HasServiceName    = (*runwayContext)(nil)
 	_ core.HasClientSet      = (*runwayContext)(nil)
+	_ kube.ResourceObserver  = (*runwayContext)(nil)
 )
 
 type runwayContext struct {
@@ -73,6 +79,10 @@ type runwayContext struct {
 	state       *state.State
 	moduleName  string
 	serviceName string
+	// whether services get network policies, see model.Environment
+	networkPolicies bool
+	// nil when the context isn't part of a running deployment
+	events *deploymentEvents
 }
 
 func (r *runwayContext) GetWingmanManager() *wingman.Manager {
@@ -110,3 +120,18 @@ func (r *runwayContext) GetModuleName() string {
 func (r *runwayContext) GetServiceName() string {
 	return r.serviceName
 }
+
+func (r *runwayContext) ResourceDeployed(ctx context.Context, ref def.ResourceRef, action kube.PlanAction) {
+	kind := model.EventResourceCreated
+	message := fmt.Sprintf("Created %s %s", ref.Kind, ref.Name)
+	switch action {
+	case kube.PlanUpdate:
+		kind = model.EventResourceUpdated
+		message = fmt.Sprintf("Updated %s %s", ref.Kind, ref.Name)
+	case kube.PlanRecreate:
+		kind = model.EventResourceRecreated
+		message = fmt.Sprintf("Recreated %s %s", ref.Kind, ref.Name)
+	}
+
+	r.events.record(ctx, kind, &r.moduleName, &r.serviceName, message)
+}
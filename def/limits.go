@@ -0,0 +1,15 @@
+package def
+
+// Limits cap what an environment can use. quantities are like the ones in
+// ResourceList, and empty means unset
+type Limits struct {
+	// quotas on the whole environment. cpu and memory cap both requests and
+	// limits
+	Cpu    string `json:"cpu,omitempty"`
+	Memory string `json:"memory,omitempty"`
+	Pods   string `json:"pods,omitempty"`
+	// limits for containers that don't set their own. requests default to
+	// these too
+	DefaultCpu    string `json:"defaultCpu,omitempty"`
+	DefaultMemory string `json:"defaultMemory,omitempty"`
+}
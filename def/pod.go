@@ -0,0 +1,64 @@
+package def
+
+type Workload string
+
+const (
+	// a long running service, replaced gradually on updates
+	WorkloadDeployment Workload = "deployment"
+	// a long running service with a stable identity and its own volumes, like
+	// a database
+	WorkloadStateful Workload = "stateful"
+	// runs once until it succeeds, like a migration
+	WorkloadJob Workload = "job"
+	// runs on a schedule
+	WorkloadCronJob Workload = "cronjob"
+)
+
+// Pod is everything that goes into running a service's container, no matter
+// what kind of workload runs it
+type Pod struct {
+	Image      string
+	Command    []string
+	Env        []Env
+	Ports      []Port
+	Probes     Probes
+	Volumes    []Volume
+	Files      []File
+	Scheduling Scheduling
+	// run to completion before the service's container starts
+	InitContainers []Container
+	// run next to the service's container
+	Sidecars []Container
+	// changes whenever the content of something the pod reads changes, like a
+	// mounted secret, so that the pod gets rolled out
+	Checksum string
+}
+
+// Volume is persistent storage that's claimed for each replica
+type Volume struct {
+	Name string
+	// where the volume is mounted in the container
+	Path string
+	// a kubernetes quantity, like 1Gi
+	Size string
+	// empty means the cluster's default storage class
+	StorageClass string
+}
+
+// File mounts a secret or a key of a config map at a path
+type File struct {
+	Path string
+	// exactly one of these is set
+	Secret    string
+	ConfigMap string
+	// the key in the config map
+	Key string
+}
+
+// Container is an extra container in the service's pod
+type Container struct {
+	Name    string
+	Image   string
+	Command []string
+	Env     []Env
+}
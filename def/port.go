@@ -0,0 +1,8 @@
+package def
+
+type Port struct {
+	Name       string
+	Port       int32
+	TargetPort int32
+	Protocol   string
+}
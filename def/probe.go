@@ -0,0 +1,32 @@
+package def
+
+type Probes struct {
+	Liveness  *Probe
+	Readiness *Probe
+	Startup   *Probe
+}
+
+// Probe checks on a container. exactly one of Http, Tcp and Exec is set, and
+// zero values for the rest mean the kubernetes default
+type Probe struct {
+	Http *HttpProbe
+	Tcp  *TcpProbe
+	Exec []string
+
+	InitialDelaySeconds int32
+	PeriodSeconds       int32
+	TimeoutSeconds      int32
+	SuccessThreshold    int32
+	FailureThreshold    int32
+}
+
+type HttpProbe struct {
+	Path string
+	// the container port
+	Port int32
+}
+
+type TcpProbe struct {
+	// the container port
+	Port int32
+}
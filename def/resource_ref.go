@@ -0,0 +1,24 @@
+package def
+
+const (
+	KindDeployment     = "Deployment"
+	KindService        = "Service"
+	KindSecret         = "Secret"
+	KindConfigMap      = "ConfigMap"
+	KindRole           = "Role"
+	KindRoleBinding    = "RoleBinding"
+	KindServiceAccount = "ServiceAccount"
+	KindIngress        = "Ingress"
+	KindStatefulSet    = "StatefulSet"
+	KindJob            = "Job"
+	KindCronJob        = "CronJob"
+	KindNetworkPolicy  = "NetworkPolicy"
+)
+
+// ResourceRef identifies a resource in an environment's namespace. these are
+// stored with the deployment state, so they don't depend on anything kube
+// specific
+type ResourceRef struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
@@ -0,0 +1,29 @@
+package def
+
+// Scheduling is how many pods a service runs and where they can run
+type Scheduling struct {
+	// defaults to 1
+	Replicas     *int32
+	Resources    Resources
+	NodeSelector map[string]string
+	Tolerations  []Toleration
+}
+
+type Resources struct {
+	Requests ResourceList
+	Limits   ResourceList
+}
+
+// ResourceList holds kubernetes quantities, like 500m or 128Mi. empty means
+// unset
+type ResourceList struct {
+	Cpu    string
+	Memory string
+}
+
+type Toleration struct {
+	Key      string
+	Operator string
+	Value    string
+	Effect   string
+}
@@ -7,25 +7,35 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/BSFishy/mora-manager/config"
+	"github.com/BSFishy/mora-manager/def"
 	"github.com/BSFishy/mora-manager/kube"
 	"github.com/BSFishy/mora-manager/model"
 	"github.com/BSFishy/mora-manager/state"
 	"github.com/BSFishy/mora-manager/util"
+	networkingv1 "k8s.io/api/networking/v1"
 )
 
-func (a *App) deploy(d *model.Deployment) {
-	ctx := context.Background()
+var (
+	DEPLOY_CONCURRENCY = util.GetenvDefault("MORA_DEPLOY_CONCURRENCY", "4")
+	DEPLOY_TIMEOUT     = util.GetenvDefault("MORA_DEPLOY_TIMEOUT", "5m")
+)
+
+// deploy runs a deployment. this should only be called by a worker that holds
+// the deployment's job, use model.DB.EnqueueDeployment to start a deployment
+func (a *App) deploy(ctx context.Context, d *model.Deployment) {
 	logger := util.LogFromCtx(ctx)
 
 	logger = logger.With("deployment", d.Id, "environment", d.EnvironmentId)
 	ctx = util.WithLogger(ctx, logger)
 
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	go a.handleDeployCancel(ctx, cancel, d)
+	events := &deploymentEvents{
+		db:         a.db,
+		deployment: d,
+	}
 
 	err := a.db.Transact(ctx, func(tx *sql.Tx) error {
 		err := d.Lock(ctx, tx)
@@ -33,6 +43,21 @@ func (a *App) deploy(d *model.Deployment) {
 			return err
 		}
 
+		// a previous deployment might still be winding down after being
+		// cancelled. wait for it to let go of the environment before touching
+		// the cluster
+		ok, err := d.TryLockEnvironment(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			logger.Info("waiting for previous deployment to stop")
+			if err = d.LockEnvironment(ctx, tx); err != nil {
+				return err
+			}
+		}
+
 		environment, err := a.db.GetEnvironment(ctx, d.EnvironmentId)
 		if err != nil {
 			return fmt.Errorf("getting environment: %w", err)
@@ -73,109 +98,347 @@ func (a *App) deploy(d *model.Deployment) {
 			return fmt.Errorf("ensuring namespace: %w", err)
 		}
 
-		services := cfg.Services[state.ServiceIndex:]
-		for _, service := range services {
-			logger := logger.With("module", service.ModuleName, "service", service.ServiceName)
-			ctx := util.WithLogger(ctx, logger)
-
-			runwayCtx := &runwayContext{
-				manager:     a.manager,
-				clientset:   a.clientset,
-				registry:    a.registry,
-				user:        user.Username,
-				environment: environment.Slug,
-				config:      &cfg,
-				state:       &state,
-				moduleName:  service.ModuleName,
-				serviceName: service.ServiceName,
+		if err = kube.ReconcileLimits(ctx, a.WithModel(user, environment), environment.Limits); err != nil {
+			return fmt.Errorf("reconciling limits: %w", err)
+		}
+
+		waiting, err := a.deployServices(ctx, events, user, environment, &cfg, &state)
+		if err != nil {
+			return err
+		}
+
+		if waiting {
+			if err = d.UpdateStateAndStatus(ctx, tx, model.Waiting, state); err != nil {
+				return fmt.Errorf("updating state: %w", err)
 			}
 
-			wm, configPoints, err := service.EvaluateWingman(ctx, runwayCtx)
+			return nil
+		}
+
+		if environment.Prune {
+			pruned, err := kube.Prune(ctx, a.WithModel(user, environment), state.Resources(), false)
 			if err != nil {
-				return fmt.Errorf("evaluating wingman: %w", err)
+				return fmt.Errorf("pruning resources: %w", err)
 			}
 
-			if len(configPoints) > 0 {
-				if err = d.UpdateStateAndStatus(ctx, tx, model.Waiting, state); err != nil {
-					return fmt.Errorf("updating state: %w", err)
-				}
-
-				logger.Info("waiting for dynamic wingman config")
-				return nil
+			if err = d.UpdatePruned(ctx, tx, pruned); err != nil {
+				return fmt.Errorf("recording pruned resources: %w", err)
 			}
+		}
 
-			if wm != nil {
-				mwm := wm.MaterializeWingman(runwayCtx)
-				if err = mwm.Deploy(ctx, runwayCtx); err != nil {
-					return fmt.Errorf("deploying wingman: %w", err)
-				}
+		if err = d.UpdateStateAndStatus(ctx, tx, model.Success, state); err != nil {
+			return fmt.Errorf("updating status to success: %w", err)
+		}
 
-				logger.Info("deployed wingman")
+		logger.Info("deployment successful")
 
-				rwm, err := a.manager.FindWingman(ctx, runwayCtx)
-				if err != nil {
-					return fmt.Errorf("finding wingman: %w", err)
-				}
+		return nil
+	})
+	if err != nil {
+		// we make errors crazy with more info. this just checks if the error chain
+		// terminates with a context canceled error
+		if strings.HasSuffix(err.Error(), context.Canceled.Error()) {
+			return
+		}
 
-				if rwm != nil {
-					cfp, err := rwm.GetConfigPoints(ctx, runwayCtx)
-					if err != nil {
-						return fmt.Errorf("getting wingman config points: %w", err)
-					}
+		logger.Error("deployment failed", "err", err)
 
-					if len(cfp) > 0 {
-						if err = d.UpdateStateAndStatus(ctx, tx, model.Waiting, state); err != nil {
-							return fmt.Errorf("updating state: %w", err)
-						}
+		failure := failureFromError(err)
+		events.record(ctx, model.EventError, failure.Module, failure.Service, failure.Message)
 
-						logger.Info("waiting for dynamic wingman config")
-						return nil
-					}
+		if err := d.Fail(ctx, a.db, failure); err != nil {
+			logger.Error("updating status to errored", "err", err)
+		}
+	}
+}
+
+// serviceError is an error that happened in a specific phase of deploying a
+// service
+type serviceError struct {
+	service *config.ServiceConfig
+	phase   model.FailurePhase
+	err     error
+}
+
+func (e *serviceError) Error() string {
+	return e.err.Error()
+}
+
+func (e *serviceError) Unwrap() error {
+	return e.err
+}
+
+// deployErr wraps err with the phase it happened in. resources that were
+// deployed but never became ready are always put in the readiness phase
+func deployErr(service *config.ServiceConfig, phase model.FailurePhase, err error) error {
+	var readinessErr *kube.ReadinessError
+	if errors.As(err, &readinessErr) {
+		phase = model.PhaseReadiness
+	}
+
+	return &serviceError{
+		service: service,
+		phase:   phase,
+		err:     err,
+	}
+}
+
+// failureFromError describes a failed deployment's error for the user
+func failureFromError(err error) model.Failure {
+	failure := model.Failure{
+		Message: err.Error(),
+	}
+
+	var svcErr *serviceError
+	if errors.As(err, &svcErr) {
+		failure.Module = &svcErr.service.ModuleName
+		failure.Service = &svcErr.service.ServiceName
+		failure.Phase = svcErr.phase
+	}
+
+	var readinessErr *kube.ReadinessError
+	if errors.As(err, &readinessErr) {
+		failure.Diagnostics = readinessErr.Diagnostics
+	}
+
+	return failure
+}
+
+type serviceResult struct {
+	service   *config.ServiceConfig
+	waiting   bool
+	resources []def.ResourceRef
+	err       error
+}
+
+// deployServices walks the dependency graph, deploying every service whose
+// dependencies are deployed at the same time, up to the configured concurrency
+// limit. it returns true if any service is waiting for configuration, in which
+// case nothing new is started and the in-flight services are allowed to finish
+func (a *App) deployServices(ctx context.Context, events *deploymentEvents, user *model.User, environment *model.Environment, cfg *config.Config, st *state.State) (bool, error) {
+	logger := util.LogFromCtx(ctx)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// evaluation reads and can modify the state (wingman functions are allowed
+	// to change configs), so it's serialized. the kubernetes deploys are the slow
+	// part and those happen outside of the lock
+	var mu sync.Mutex
+
+	// without a dependency graph, the stored order is the only thing that says
+	// what has to come first
+	concurrency := a.concurrency
+	if !cfg.HasDependencyGraph() {
+		concurrency = 1
+	}
+
+	results := make(chan serviceResult)
+	started := map[state.ServiceRef]bool{}
+	inFlight := 0
+	waiting := false
+
+	var deployErr error
+	for {
+		if deployErr == nil && !waiting {
+			mu.Lock()
+			for i := range cfg.Services {
+				if inFlight >= concurrency {
+					break
 				}
-			}
 
-			def, configPoints, err := service.Evaluate(ctx, runwayCtx)
-			if err != nil {
-				return fmt.Errorf("evaluating service: %w", err)
-			}
+				service := &cfg.Services[i]
+				ref := service.Ref()
+				if started[ref] || st.IsDeployed(ref) || !service.DependenciesDeployed(st) {
+					continue
+				}
 
-			if len(configPoints) > 0 {
-				if err = d.UpdateStateAndStatus(ctx, tx, model.Waiting, state); err != nil {
-					return fmt.Errorf("updating state: %w", err)
+				started[ref] = true
+				inFlight++
+
+				runwayCtx := &runwayContext{
+					manager:         a.manager,
+					clientset:       a.clientset,
+					registry:        a.registry,
+					user:            user.Username,
+					environment:     environment.Slug,
+					config:          cfg,
+					state:           st,
+					moduleName:      service.ModuleName,
+					serviceName:     service.ServiceName,
+					networkPolicies: environment.NetworkPolicies,
+					events:          events,
 				}
 
-				logger.Info("waiting for dynamic config")
-				return nil
+				go func() {
+					logger := logger.With("module", service.ModuleName, "service", service.ServiceName)
+					ctx := util.WithLogger(ctx, logger)
+
+					waiting, resources, err := a.deployService(ctx, &mu, runwayCtx, service)
+					results <- serviceResult{
+						service:   service,
+						waiting:   waiting,
+						resources: resources,
+						err:       err,
+					}
+				}()
 			}
+			mu.Unlock()
+		}
+
+		if inFlight == 0 {
+			break
+		}
+
+		result := <-results
+		inFlight--
 
-			deployment := def.Materialize(runwayCtx)
-			if err = deployment.Deploy(ctx, runwayCtx); err != nil {
-				return fmt.Errorf("deploying service: %w", err)
+		if result.err != nil {
+			if deployErr == nil {
+				deployErr = fmt.Errorf("deploying %s/%s: %w", result.service.ModuleName, result.service.ServiceName, result.err)
+
+				// stop everything else that is still running. the deployment is going
+				// to be marked as errored anyway
+				cancel()
 			}
 
-			state.ServiceIndex++
-			logger.Info("deployed service")
+			continue
 		}
 
-		if err = d.UpdateStateAndStatus(ctx, tx, model.Success, state); err != nil {
-			return fmt.Errorf("updating status to success: %w", err)
+		status := state.ServiceDeployed
+		if result.waiting {
+			status = state.ServiceWaiting
+			waiting = true
 		}
 
-		logger.Info("deployment successful")
+		mu.Lock()
+		ss := st.SetServiceStatus(result.service.ModuleName, result.service.ServiceName, status)
+		ss.Resources = result.resources
+		mu.Unlock()
+	}
 
-		return nil
-	})
+	if deployErr != nil {
+		return false, deployErr
+	}
+
+	if waiting {
+		return true, nil
+	}
+
+	// if nothing is running and nothing could be started, whatever is left has
+	// dependencies that can never be satisfied
+	for _, service := range cfg.Services {
+		if !st.IsDeployed(service.Ref()) {
+			return false, fmt.Errorf("unable to resolve dependencies of %s/%s", service.ModuleName, service.ServiceName)
+		}
+	}
+
+	return false, nil
+}
+
+// deployService deploys a single service along with its wingman, returning true
+// if it is waiting for configuration. otherwise, it returns the resources that
+// were deployed. mu guards the deployment state
+func (a *App) deployService(ctx context.Context, mu *sync.Mutex, runwayCtx *runwayContext, service *config.ServiceConfig) (bool, []def.ResourceRef, error) {
+	logger := util.LogFromCtx(ctx)
+	events := runwayCtx.events
+	module, name := &service.ModuleName, &service.ServiceName
+
+	events.record(ctx, model.EventServiceStarted, module, name, "Started deploying service")
+
+	mu.Lock()
+	wm, configPoints, err := service.EvaluateWingman(ctx, runwayCtx)
+	mu.Unlock()
 	if err != nil {
-		// we make errors crazy with more info. this just checks if the error chain
-		// terminates with a context canceled error
-		if strings.HasSuffix(err.Error(), context.Canceled.Error()) {
-			return
+		return false, nil, deployErr(service, model.PhaseEvaluate, fmt.Errorf("evaluating wingman: %w", err))
+	}
+
+	if len(configPoints) > 0 {
+		logger.Info("waiting for dynamic wingman config")
+		events.record(ctx, model.EventWaitingForConfig, module, name, "Waiting for wingman config")
+		return true, nil, nil
+	}
+
+	timeout := service.Timeout
+	if timeout == 0 {
+		timeout = a.deployTimeout
+	}
+
+	resources := []def.ResourceRef{}
+	if wm != nil {
+		mwm := wm.MaterializeWingman(runwayCtx)
+		if err = deployWithTimeout(ctx, runwayCtx, mwm, timeout); err != nil {
+			return false, nil, deployErr(service, model.PhaseWingman, fmt.Errorf("deploying wingman: %w", err))
 		}
 
-		logger.Error("deployment failed", "err", err)
+		logger.Info("deployed wingman")
+		events.record(ctx, model.EventWingmanDeployed, module, name, "Deployed wingman")
+		resources = append(resources, mwm.Refs()...)
 
-		if err := d.UpdateStatusDb(ctx, a.db, model.Errored); err != nil {
-			logger.Error("updating status to errored", "err", err)
+		rwm, err := a.manager.FindWingman(ctx, runwayCtx)
+		if err != nil {
+			return false, nil, deployErr(service, model.PhaseWingman, fmt.Errorf("finding wingman: %w", err))
+		}
+
+		if rwm != nil {
+			mu.Lock()
+			cfp, err := rwm.GetConfigPoints(ctx, runwayCtx)
+			mu.Unlock()
+			if err != nil {
+				return false, nil, deployErr(service, model.PhaseWingman, fmt.Errorf("getting wingman config points: %w", err))
+			}
+
+			if len(cfp) > 0 {
+				logger.Info("waiting for dynamic wingman config")
+				events.record(ctx, model.EventWaitingForConfig, module, name, "Waiting for config requested by the wingman")
+				return true, nil, nil
+			}
 		}
 	}
+
+	mu.Lock()
+	definition, configPoints, err := service.Evaluate(ctx, runwayCtx)
+	mu.Unlock()
+	if err != nil {
+		return false, nil, deployErr(service, model.PhaseEvaluate, fmt.Errorf("evaluating service: %w", err))
+	}
+
+	if len(configPoints) > 0 {
+		logger.Info("waiting for dynamic config")
+		events.record(ctx, model.EventWaitingForConfig, module, name, "Waiting for config")
+		return true, nil, nil
+	}
+
+	deployment := materialize(runwayCtx, service, definition)
+	if err = deployWithTimeout(ctx, runwayCtx, deployment, timeout); err != nil {
+		return false, nil, deployErr(service, model.PhaseMaterialize, fmt.Errorf("deploying service: %w", err))
+	}
+
+	logger.Info("deployed service")
+	events.record(ctx, model.EventServiceDeployed, module, name, "Deployed service")
+	resources = append(resources, deployment.Refs()...)
+
+	return false, resources, nil
+}
+
+// materialize gets the resources that make up the service, isolating it if the
+// environment wants that
+func materialize(runwayCtx *runwayContext, service *config.ServiceConfig, definition *config.ServiceDefinition) *kube.MaterializedService {
+	resources := definition.Materialize(runwayCtx)
+	if runwayCtx.networkPolicies {
+		resources.NetworkPolicies = []kube.Resource[networkingv1.NetworkPolicy]{
+			definition.MaterializeNetworkPolicy(runwayCtx, runwayCtx.config.Dependents(service.Ref())),
+		}
+	}
+
+	return resources
+}
+
+// deployWithTimeout deploys the resources, giving up if they aren't all ready
+// within the timeout
+func deployWithTimeout(ctx context.Context, runwayCtx *runwayContext, resources *kube.MaterializedService, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return resources.Deploy(ctx, runwayCtx)
 }
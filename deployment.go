@@ -3,12 +3,14 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/BSFishy/mora-manager/api"
 	"github.com/BSFishy/mora-manager/config"
+	"github.com/BSFishy/mora-manager/def"
 	"github.com/BSFishy/mora-manager/kube"
 	"github.com/BSFishy/mora-manager/model"
 	"github.com/BSFishy/mora-manager/point"
@@ -23,6 +25,43 @@ type DeploymentResponse struct {
 	Id string `json:"id"`
 }
 
+type DependencyErrorResponse struct {
+	Error string `json:"error"`
+	// set when a service requires a service that doesn't exist
+	Service  *statepkg.ServiceRef `json:"service,omitempty"`
+	Requires *statepkg.ServiceRef `json:"requires,omitempty"`
+	// set when the requires graph has a cycle
+	Cycle []statepkg.ServiceRef `json:"cycle,omitempty"`
+}
+
+// writeDependencyError writes a bad request response if err is an invalid
+// dependency graph. returns false if err is something else
+func writeDependencyError(w http.ResponseWriter, err error) (bool, error) {
+	var response DependencyErrorResponse
+
+	var unknownErr *config.UnknownServiceError
+	var cycleErr *config.CycleError
+	if errors.As(err, &unknownErr) {
+		response = DependencyErrorResponse{
+			Error:    unknownErr.Error(),
+			Service:  &unknownErr.Service,
+			Requires: &unknownErr.Requires,
+		}
+	} else if errors.As(err, &cycleErr) {
+		response = DependencyErrorResponse{
+			Error: cycleErr.Error(),
+			Cycle: cycleErr.Path,
+		}
+	} else {
+		return false, nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+
+	return true, json.NewEncoder(w).Encode(response)
+}
+
 func (a *App) createDeployment(w http.ResponseWriter, req *http.Request) error {
 	var cfg api.Config
 	if err := json.NewDecoder(req.Body).Decode(&cfg); err != nil {
@@ -46,10 +85,6 @@ func (a *App) createDeployment(w http.ResponseWriter, req *http.Request) error {
 		return nil
 	}
 
-	if err = environment.CancelInProgressDeployments(ctx, a.db); err != nil {
-		return fmt.Errorf("cancelling deployments: %w", err)
-	}
-
 	modelCtx := a.WithModel(user, environment)
 
 	// at this point, we shouldnt be actually referencing any configuration or
@@ -58,6 +93,10 @@ func (a *App) createDeployment(w http.ResponseWriter, req *http.Request) error {
 	// returning empty values for these for safety?
 	services, err := config.ServiceConfigFromModules(ctx, modelCtx, cfg.Modules)
 	if err != nil {
+		if ok, err := writeDependencyError(w, err); ok {
+			return err
+		}
+
 		return fmt.Errorf("sorting services: %w", err)
 	}
 
@@ -78,21 +117,100 @@ func (a *App) createDeployment(w http.ResponseWriter, req *http.Request) error {
 		previousDeploymentId = &previousDeployment.Id
 	}
 
-	deployment, err := environment.NewDeployment(ctx, a.db, previousDeploymentId, config.Config{
-		Services: services,
-		Configs:  configs,
+	// only cancel once the new config is known to be valid, so a bad request
+	// doesn't take down a good deployment. the new deployment replaces the
+	// cancelled ones all at once, or not at all
+	var deployment *model.Deployment
+	err = a.db.Transact(ctx, func(tx *sql.Tx) error {
+		if err := environment.CancelInProgressDeployments(ctx, tx); err != nil {
+			return fmt.Errorf("cancelling deployments: %w", err)
+		}
+
+		var err error
+		deployment, err = environment.NewDeployment(ctx, tx, previousDeploymentId, config.Config{
+			Services: services,
+			Configs:  configs,
+		})
+		if err != nil {
+			return fmt.Errorf("creating deployment: %w", err)
+		}
+
+		if err = model.EnqueueDeploymentTx(ctx, tx, deployment.Id); err != nil {
+			return fmt.Errorf("queueing deployment: %w", err)
+		}
+
+		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("creating deployment: %w", err)
+		return err
 	}
 
-	go a.deploy(deployment)
-
 	return json.NewEncoder(w).Encode(DeploymentResponse{
 		Id: deployment.Id,
 	})
 }
 
+// getApiDeployment gets the deployment from the route params, making sure it
+// belongs to the environment and the user. it writes a not found response and
+// returns nil if it doesn't
+func (a *App) getApiDeployment(w http.ResponseWriter, req *http.Request) (*model.Deployment, error) {
+	ctx := req.Context()
+	user, _ := model.GetUser(ctx)
+
+	params := router.Params(req)
+	environmentSlug := params["slug"]
+	id := params["id"]
+
+	environment, err := a.db.GetEnvironmentBySlug(ctx, user.Id, environmentSlug)
+	if err != nil {
+		return nil, fmt.Errorf("getting environment: %w", err)
+	}
+
+	if environment == nil || environment.UserId != user.Id {
+		http.NotFound(w, req)
+		return nil, nil
+	}
+
+	deployment, err := a.db.GetDeployment(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("getting deployment: %w", err)
+	}
+
+	if deployment == nil || deployment.EnvironmentId != environment.Id {
+		http.NotFound(w, req)
+		return nil, nil
+	}
+
+	return deployment, nil
+}
+
+type DeploymentStatusResponse struct {
+	Id           string                 `json:"id"`
+	Status       model.DeploymentStatus `json:"status"`
+	StatusReason *string                `json:"statusReason,omitempty"`
+	// see model.Failure
+	Failure *json.RawMessage `json:"failure,omitempty"`
+}
+
+func (a *App) getDeploymentStatus(w http.ResponseWriter, req *http.Request) error {
+	deployment, err := a.getApiDeployment(w, req)
+	if err != nil {
+		return err
+	}
+
+	if deployment == nil {
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(DeploymentStatusResponse{
+		Id:           deployment.Id,
+		Status:       deployment.Status,
+		StatusReason: deployment.StatusReason,
+		Failure:      deployment.Failure,
+	})
+}
+
 func (a *App) deploymentHtmxRoute(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	user, _ := model.GetUser(ctx)
@@ -216,41 +334,29 @@ func (a *App) updateDeploymentConfigHtmxRoute(w http.ResponseWriter, r *http.Req
 			}
 		}
 
+		waitingServices, err := a.findWaitingConfigPoints(ctx, user, env, &cfg, &state)
+		if err != nil {
+			return err
+		}
+
 		for i := range moduleNames {
 			moduleName := moduleNames[i]
 			identifier := identifiers[i]
 			v := []byte(values[i])
 			inherit := inherits[i]
 
-			services := cfg.Services[state.ServiceIndex:]
-			if len(services) < 1 {
-				return fmt.Errorf("invalid service to configure: %d", state.ServiceIndex)
-			}
-
-			service := services[0]
-			if service.ModuleName != moduleName {
-				w.WriteHeader(http.StatusBadRequest)
-				return nil
-			}
+			var (
+				c         *point.Point
+				runwayCtx *runwayContext
+			)
 
-			runwayCtx := &runwayContext{
-				manager:     nil,
-				clientset:   a.clientset,
-				registry:    a.registry,
-				user:        user.Username,
-				environment: env.Slug,
-				config:      &cfg,
-				state:       &state,
-				moduleName:  moduleName,
-				serviceName: service.ServiceName,
-			}
-
-			cfps, err := FindConfigPoints(ctx, runwayCtx, &service)
-			if err != nil {
-				return fmt.Errorf("finding config points: %w", err)
+			for _, service := range waitingServices {
+				if c = service.Find(moduleName, identifier); c != nil {
+					runwayCtx = service.runwayCtx
+					break
+				}
 			}
 
-			c := cfps.Find(moduleName, identifier)
 			if c == nil {
 				w.WriteHeader(http.StatusBadRequest)
 				return nil
@@ -282,6 +388,7 @@ func (a *App) updateDeploymentConfigHtmxRoute(w http.ResponseWriter, r *http.Req
 					Name:       identifier,
 					Kind:       point.Secret,
 					Value:      []byte(secret.Name()),
+					Checksum:   statepkg.Checksum(v),
 				})
 			} else {
 				state.Configs = append(state.Configs, statepkg.StateConfig{
@@ -297,14 +404,16 @@ func (a *App) updateDeploymentConfigHtmxRoute(w http.ResponseWriter, r *http.Req
 			return fmt.Errorf("updating state: %w", err)
 		}
 
+		if err := model.EnqueueDeploymentTx(ctx, tx, d.Id); err != nil {
+			return fmt.Errorf("queueing deployment: %w", err)
+		}
+
 		return nil
 	})
 	if err != nil {
 		return err
 	}
 
-	go a.deploy(d)
-
 	props, err := a.getDeploymentProps(w, r)
 	if err != nil {
 		return fmt.Errorf("getting props: %w", err)
@@ -374,28 +483,20 @@ func (a *App) getDeploymentProps(w http.ResponseWriter, r *http.Request) (*templ
 			}
 		}
 
-		services := cfg.Services[state.ServiceIndex:]
-		if len(services) > 0 {
-			service := services[0]
-
-			runwayCtx := &runwayContext{
-				manager:     nil,
-				clientset:   a.clientset,
-				registry:    a.registry,
-				user:        user.Username,
-				environment: environment.Slug,
-				config:      &cfg,
-				state:       &state,
-				moduleName:  service.ModuleName,
-				serviceName: service.ServiceName,
-			}
+		waitingServices, err := a.findWaitingConfigPoints(ctx, user, environment, &cfg, &state)
+		if err != nil {
+			return nil, err
+		}
 
-			configPoints, err = FindConfigPoints(ctx, runwayCtx, &service)
-			if err != nil {
-				return nil, fmt.Errorf("finding config points: %w", err)
-			}
+		for _, service := range waitingServices {
+			for _, p := range service.configPoints {
+				// multiple services in a module can wait on the same module config
+				if point.Points(configPoints).Find(p.ModuleName, p.Identifier) != nil {
+					continue
+				}
+
+				configPoints = append(configPoints, p)
 
-			for _, p := range configPoints {
 				stateValue := previousState.FindConfig(p.ModuleName, p.Identifier)
 				if stateValue != nil {
 					if stateValue.Kind == point.Secret {
@@ -410,11 +511,35 @@ func (a *App) getDeploymentProps(w http.ResponseWriter, r *http.Request) (*templ
 		}
 	}
 
+	var pruned []def.ResourceRef
+	if deployment.Pruned != nil {
+		if err = json.Unmarshal(*deployment.Pruned, &pruned); err != nil {
+			return nil, fmt.Errorf("decoding pruned resources: %w", err)
+		}
+	}
+
+	var failure *model.Failure
+	if deployment.Failure != nil {
+		if err = json.Unmarshal(*deployment.Failure, &failure); err != nil {
+			return nil, fmt.Errorf("decoding failure: %w", err)
+		}
+	}
+
+	events, err := deployment.GetEvents(ctx, a.db)
+	if err != nil {
+		return nil, fmt.Errorf("getting events: %w", err)
+	}
+
 	return &templates.DeploymentProps{
 		Id:           deployment.Id,
 		Status:       deployment.Status,
 		ConfigPoints: configPoints,
 		Values:       values,
+		Pruned:       pruned,
+		RollbackOfId: deployment.RollbackOfId,
+		StatusReason: deployment.StatusReason,
+		Failure:      failure,
+		Events:       events,
 	}, nil
 }
 
@@ -3,9 +3,13 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/BSFishy/mora-manager/def"
+	"github.com/BSFishy/mora-manager/kube"
 	"github.com/BSFishy/mora-manager/model"
+	"github.com/BSFishy/mora-manager/router"
 	"github.com/BSFishy/mora-manager/templates"
 )
 
@@ -77,3 +81,210 @@ func (a *App) deleteEnvironmentHtmxRoute(w http.ResponseWriter, r *http.Request)
 
 	return templates.DashboardEnvironments(environments).Render(ctx, w)
 }
+
+func (a *App) pruneEnvironmentHtmxRoute(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user, _ := model.GetUser(ctx)
+
+	if err := r.ParseForm(); err != nil {
+		return fmt.Errorf("parsing form: %w", err)
+	}
+
+	environmentId := r.Form.Get("id")
+	if environmentId == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	}
+
+	prune, err := strconv.ParseBool(r.Form.Get("prune"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	}
+
+	environment, err := a.db.GetEnvironment(ctx, environmentId)
+	if err != nil {
+		return fmt.Errorf("getting environment: %w", err)
+	}
+
+	if environment == nil || environment.UserId != user.Id {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	}
+
+	if err = environment.SetPrune(ctx, a.db, prune); err != nil {
+		return fmt.Errorf("updating environment: %w", err)
+	}
+
+	environments, err := a.db.GetUserEnvironments(ctx, user.Id)
+	if err != nil {
+		return fmt.Errorf("getting user environments: %w", err)
+	}
+
+	return templates.DashboardEnvironments(environments).Render(ctx, w)
+}
+
+func (a *App) networkPoliciesEnvironmentHtmxRoute(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user, _ := model.GetUser(ctx)
+
+	if err := r.ParseForm(); err != nil {
+		return fmt.Errorf("parsing form: %w", err)
+	}
+
+	environmentId := r.Form.Get("id")
+	if environmentId == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	}
+
+	networkPolicies, err := strconv.ParseBool(r.Form.Get("network_policies"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	}
+
+	environment, err := a.db.GetEnvironment(ctx, environmentId)
+	if err != nil {
+		return fmt.Errorf("getting environment: %w", err)
+	}
+
+	if environment == nil || environment.UserId != user.Id {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	}
+
+	if err = environment.SetNetworkPolicies(ctx, a.db, networkPolicies); err != nil {
+		return fmt.Errorf("updating environment: %w", err)
+	}
+
+	// policies are only created while deploying, but they're removed right away
+	// so nothing stays locked down until the next deployment
+	if !networkPolicies {
+		if err = kube.DeleteNetworkPolicies(ctx, a.WithModel(user, environment)); err != nil {
+			return fmt.Errorf("deleting network policies: %w", err)
+		}
+	}
+
+	environments, err := a.db.GetUserEnvironments(ctx, user.Id)
+	if err != nil {
+		return fmt.Errorf("getting user environments: %w", err)
+	}
+
+	return templates.DashboardEnvironments(environments).Render(ctx, w)
+}
+
+func (a *App) environmentLimitsPage(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user, _ := model.GetUser(ctx)
+
+	params := router.Params(r)
+	environment, err := a.db.GetEnvironment(ctx, params["id"])
+	if err != nil {
+		return fmt.Errorf("getting environment: %w", err)
+	}
+
+	// admins manage the limits of every environment, so they can see them too
+	if environment == nil || (environment.UserId != user.Id && !user.Admin) {
+		http.NotFound(w, r)
+		return nil
+	}
+
+	return templates.EnvironmentLimits(templates.EnvironmentLimitsProps{
+		Environment: *environment,
+		Admin:       user.Admin,
+	}).Render(ctx, w)
+}
+
+// adminEnvironmentsPage lists the environments of every user, so admins can
+// get to their limits
+func (a *App) adminEnvironmentsPage(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user, _ := model.GetUser(ctx)
+
+	if !user.Admin {
+		http.NotFound(w, r)
+		return nil
+	}
+
+	environments, err := a.db.GetAllEnvironments(ctx)
+	if err != nil {
+		return fmt.Errorf("getting environments: %w", err)
+	}
+
+	return templates.AdminEnvironments(environments).Render(ctx, w)
+}
+
+func (a *App) limitsEnvironmentHtmxRoute(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user, _ := model.GetUser(ctx)
+
+	if !user.Admin {
+		w.WriteHeader(http.StatusForbidden)
+		return nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return fmt.Errorf("parsing form: %w", err)
+	}
+
+	environmentId := r.Form.Get("id")
+	if environmentId == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	}
+
+	environment, err := a.db.GetEnvironment(ctx, environmentId)
+	if err != nil {
+		return fmt.Errorf("getting environment: %w", err)
+	}
+
+	if environment == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	}
+
+	limits := def.Limits{
+		Cpu:           strings.TrimSpace(r.Form.Get("cpu")),
+		Memory:        strings.TrimSpace(r.Form.Get("memory")),
+		Pods:          strings.TrimSpace(r.Form.Get("pods")),
+		DefaultCpu:    strings.TrimSpace(r.Form.Get("default_cpu")),
+		DefaultMemory: strings.TrimSpace(r.Form.Get("default_memory")),
+	}
+
+	props := templates.EnvironmentLimitsProps{
+		Environment: *environment,
+		Admin:       true,
+	}
+
+	if err = kube.ValidateLimits(limits); err != nil {
+		props.Environment.Limits = limits
+		props.Error = err.Error()
+		return templates.EnvironmentLimitsForm(props).Render(ctx, w)
+	}
+
+	if err = environment.SetLimits(ctx, a.db, limits); err != nil {
+		return fmt.Errorf("updating environment: %w", err)
+	}
+
+	// the namespace belongs to the environment's owner, who isn't necessarily
+	// the admin changing the limits
+	owner, err := a.db.GetUserById(ctx, environment.UserId)
+	if err != nil {
+		return fmt.Errorf("getting user: %w", err)
+	}
+
+	// applied right away so that the limits don't wait for the next deployment
+	deps := a.WithModel(owner, environment)
+	if err = kube.EnsureNamespace(ctx, deps); err != nil {
+		return fmt.Errorf("ensuring namespace: %w", err)
+	}
+
+	if err = kube.ReconcileLimits(ctx, deps, limits); err != nil {
+		return fmt.Errorf("reconciling limits: %w", err)
+	}
+
+	props.Environment = *environment
+	props.Saved = true
+	return templates.EnvironmentLimitsForm(props).Render(ctx, w)
+}
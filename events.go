@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/BSFishy/mora-manager/model"
+	"github.com/BSFishy/mora-manager/util"
+)
+
+// deploymentEvents records the events of a running deployment. the events are
+// only there to help the user follow along, so failing to record one doesn't
+// fail the deployment
+type deploymentEvents struct {
+	db         *model.DB
+	deployment *model.Deployment
+}
+
+func (e *deploymentEvents) record(ctx context.Context, kind model.EventKind, module, service *string, message string) {
+	if e == nil {
+		return
+	}
+
+	if err := e.deployment.AddEvent(ctx, e.db, kind, module, service, message); err != nil {
+		util.LogFromCtx(ctx).Error("failed to record deployment event", "kind", kind, "err", err)
+	}
+}
+
+type EventResponse struct {
+	Kind      model.EventKind `json:"kind"`
+	Module    *string         `json:"module,omitempty"`
+	Service   *string         `json:"service,omitempty"`
+	Message   string          `json:"message"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+func (a *App) getDeploymentEvents(w http.ResponseWriter, req *http.Request) error {
+	ctx := req.Context()
+
+	deployment, err := a.getApiDeployment(w, req)
+	if err != nil {
+		return err
+	}
+
+	if deployment == nil {
+		return nil
+	}
+
+	events, err := deployment.GetEvents(ctx, a.db)
+	if err != nil {
+		return fmt.Errorf("getting events: %w", err)
+	}
+
+	response := make([]EventResponse, len(events))
+	for i, event := range events {
+		response[i] = EventResponse{
+			Kind:      event.Kind,
+			Module:    event.Module,
+			Service:   event.Service,
+			Message:   event.Message,
+			CreatedAt: event.CreatedAt,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(response)
+}
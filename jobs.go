@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/BSFishy/mora-manager/model"
+	"github.com/BSFishy/mora-manager/util"
+)
+
+var (
+	WORKERS   = util.GetenvDefault("MORA_WORKERS", "2")
+	JOB_LEASE = util.GetenvDefault("MORA_JOB_LEASE", "30s")
+)
+
+// a job that keeps getting picked back up is probably taking down whatever
+// worker runs it
+const maxJobAttempts = 3
+
+// startWorkers starts the workers that run queued deployments. every manager
+// replica runs its own workers, and they coordinate through the job table
+func (a *App) startWorkers(ctx context.Context) {
+	for i := range a.workers {
+		go a.runWorker(ctx, fmt.Sprintf("%s-%d", a.workerId, i))
+	}
+}
+
+func (a *App) runWorker(ctx context.Context, worker string) {
+	logger := util.LogFromCtx(ctx).With("worker", worker)
+	ctx = util.WithLogger(ctx, logger)
+
+	for {
+		job, err := a.db.ClaimJob(ctx, worker, a.lease)
+		if err != nil {
+			logger.Error("failed to claim job", "err", err)
+		}
+
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+
+			continue
+		}
+
+		a.runJob(ctx, job)
+	}
+}
+
+func (a *App) runJob(ctx context.Context, job *model.Job) {
+	logger := util.LogFromCtx(ctx).With("job", job.Id)
+	ctx = util.WithLogger(ctx, logger)
+
+	defer func() {
+		if err := job.Finish(ctx, a.db); err != nil {
+			logger.Error("failed to finish job", "err", err)
+		}
+	}()
+
+	d, err := a.db.GetDeployment(ctx, job.DeploymentId)
+	if err != nil {
+		logger.Error("failed to get deployment for job", "err", err)
+		return
+	}
+
+	if d == nil {
+		logger.Warn("job references a missing deployment")
+		return
+	}
+
+	if job.Attempts > maxJobAttempts {
+		logger.Error("giving up on deployment", "attempts", job.Attempts)
+
+		err = a.db.Transact(ctx, func(tx *sql.Tx) error {
+			return d.UpdateStatusWithReason(ctx, tx, model.Errored, fmt.Sprintf("The deployment was abandoned after %d attempts. The manager may have crashed while running it.", maxJobAttempts))
+		})
+		if err != nil {
+			logger.Error("updating status to errored", "err", err)
+		}
+
+		return
+	}
+
+	// subscribe before anything else so a cancellation can't slip in between
+	// loading the deployment and starting to listen
+	cancelled, unsubscribe := a.notifier.Subscribe(d.Id)
+	defer unsubscribe()
+
+	deployCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go a.handleDeployCancel(deployCtx, cancel, cancelled)
+	go a.holdLease(deployCtx, cancel, job)
+
+	a.deploy(deployCtx, d)
+}
@@ -0,0 +1,112 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/BSFishy/mora-manager/util"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/csaupgrade"
+)
+
+// FieldManager is who kubernetes records as the owner of the fields that we
+// apply
+const FieldManager = "mora"
+
+// legacyFieldManager is who kubernetes recorded as the owner of the fields of
+// resources that were created and updated before we used server-side apply.
+// without a field manager, it's the start of the client's user agent
+var legacyFieldManager = strings.Split(rest.DefaultKubernetesUserAgent(), "/")[0]
+
+type applier[T, C any] interface {
+	Apply(ctx context.Context, cfg C, opts metav1.ApplyOptions) (*T, error)
+}
+
+// apply server-side applies cfg, creating the resource if it doesn't exist.
+// conflicts are forced since we own everything we deploy. a dry run goes
+// through defaulting and validation like a real apply, but doesn't persist
+// anything
+func apply[T, C any](ctx context.Context, client applier[T, C], cfg C, dryRun bool) (*T, error) {
+	opts := metav1.ApplyOptions{
+		FieldManager: FieldManager,
+		Force:        true,
+	}
+
+	if dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+
+	return client.Apply(ctx, cfg, opts)
+}
+
+// changed reports whether applying turned before into something else. the
+// bookkeeping that kubernetes does on every write and the status, which is
+// never applied, are left out
+func changed(before, after any) (bool, error) {
+	beforeObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(before)
+	if err != nil {
+		return false, fmt.Errorf("converting resource: %w", err)
+	}
+
+	afterObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(after)
+	if err != nil {
+		return false, fmt.Errorf("converting resource: %w", err)
+	}
+
+	for _, obj := range []map[string]any{beforeObj, afterObj} {
+		unstructured.RemoveNestedField(obj, "metadata", "managedFields")
+		unstructured.RemoveNestedField(obj, "metadata", "resourceVersion")
+		unstructured.RemoveNestedField(obj, "status")
+	}
+
+	return !equality.Semantic.DeepEqual(beforeObj, afterObj), nil
+}
+
+// upgradeManagedFields hands the fields of a resource that we created or
+// updated before we used server-side apply over to FieldManager. an apply only
+// removes fields that its own manager owned, so without this, fields that get
+// dropped from the config would stay on the resource forever. resources that
+// are already owned by FieldManager are left alone
+func upgradeManagedFields[T any](ctx context.Context, deps KubeContext, res Resource[T], found *T) (*T, error) {
+	obj, ok := any(found).(runtime.Object)
+	if !ok {
+		return found, nil
+	}
+
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, fmt.Errorf("reading resource metadata: %w", err)
+	}
+
+	for _, entry := range accessor.GetManagedFields() {
+		if entry.Manager == FieldManager && entry.Operation == metav1.ManagedFieldsOperationApply {
+			return found, nil
+		}
+	}
+
+	patch, err := csaupgrade.UpgradeManagedFieldsPatch(obj, sets.New(legacyFieldManager), FieldManager)
+	if err != nil {
+		return nil, fmt.Errorf("upgrading managed fields: %w", err)
+	}
+
+	if patch == nil {
+		return found, nil
+	}
+
+	util.LogFromCtx(ctx).Info("taking over fields from before server-side apply", "manager", legacyFieldManager)
+
+	upgraded, err := res.Patch(ctx, deps, types.JSONPatchType, patch)
+	if err != nil {
+		return nil, fmt.Errorf("patching managed fields: %w", err)
+	}
+
+	return upgraded, nil
+}
@@ -0,0 +1,93 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/BSFishy/mora-manager/core"
+	"github.com/BSFishy/mora-manager/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+// ConfigMap holds plain text config. it's named the same way secrets are
+type ConfigMap struct {
+	moduleName string
+	identifier string
+	data       map[string]string
+	// shared by every service in the module, so it isn't labelled with any one
+	// of them
+	shared bool
+}
+
+func NewConfigMap(deps interface {
+	core.HasModuleName
+}, identifier string, data map[string]string,
+) Resource[corev1.ConfigMap] {
+	return &ConfigMap{
+		moduleName: deps.GetModuleName(),
+		identifier: identifier,
+		data:       data,
+	}
+}
+
+// NewModuleConfigMap makes a config map that belongs to the module rather than
+// the service deploying it
+func NewModuleConfigMap(deps interface {
+	core.HasModuleName
+}, identifier string, data map[string]string,
+) Resource[corev1.ConfigMap] {
+	return &ConfigMap{
+		moduleName: deps.GetModuleName(),
+		identifier: identifier,
+		data:       data,
+		shared:     true,
+	}
+}
+
+func (c *ConfigMap) Name() string {
+	return util.SanitizeDNS1123Subdomain(fmt.Sprintf("%s-%s", c.moduleName, c.identifier))
+}
+
+func (c *ConfigMap) Get(ctx context.Context, deps KubeContext) (*corev1.ConfigMap, error) {
+	return deps.GetClientset().CoreV1().ConfigMaps(namespace(deps)).Get(ctx, c.Name(), metav1.GetOptions{})
+}
+
+func (c *ConfigMap) Patch(ctx context.Context, deps KubeContext, patchType types.PatchType, data []byte) (*corev1.ConfigMap, error) {
+	return deps.GetClientset().CoreV1().ConfigMaps(namespace(deps)).Patch(ctx, c.Name(), patchType, data, metav1.PatchOptions{})
+}
+
+func (c *ConfigMap) Delete(ctx context.Context, deps KubeContext) error {
+	return deps.GetClientset().CoreV1().ConfigMaps(namespace(deps)).Delete(ctx, c.Name(), metav1.DeleteOptions{})
+}
+
+func (c *ConfigMap) build(deps KubeContext) *corev1apply.ConfigMapApplyConfiguration {
+	labels := matchLabels(deps, map[string]string{
+		"mora.identifier": c.identifier,
+	})
+
+	// applying without the label takes it off the ones labelled before
+	if c.shared {
+		delete(labels, "mora.service")
+	}
+
+	return corev1apply.ConfigMap(c.Name(), namespace(deps)).
+		WithLabels(labels).
+		WithData(c.data)
+}
+
+func (c *ConfigMap) Apply(ctx context.Context, deps KubeContext, dryRun bool) (*corev1.ConfigMap, error) {
+	return apply(ctx, deps.GetClientset().CoreV1().ConfigMaps(namespace(deps)), c.build(deps), dryRun)
+}
+
+func (c *ConfigMap) Watch(ctx context.Context, deps KubeContext, opts metav1.ListOptions) (watch.Interface, error) {
+	return deps.GetClientset().CoreV1().ConfigMaps(namespace(deps)).Watch(ctx, opts)
+}
+
+func (c *ConfigMap) Ready(configMap *corev1.ConfigMap) bool {
+	// like secrets, config maps are available as soon as they exist
+	return true
+}
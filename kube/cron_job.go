@@ -0,0 +1,87 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/BSFishy/mora-manager/core"
+	"github.com/BSFishy/mora-manager/def"
+	"github.com/BSFishy/mora-manager/util"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	batchv1apply "k8s.io/client-go/applyconfigurations/batch/v1"
+)
+
+// CronJob runs a service on a schedule
+type CronJob struct {
+	moduleName  string
+	serviceName string
+	schedule    string
+	pod         def.Pod
+}
+
+func NewCronJob(deps interface {
+	core.HasModuleName
+	core.HasServiceName
+}, schedule string, pod def.Pod,
+) Resource[batchv1.CronJob] {
+	return &CronJob{
+		moduleName:  deps.GetModuleName(),
+		serviceName: deps.GetServiceName(),
+		schedule:    schedule,
+		pod:         pod,
+	}
+}
+
+func (c *CronJob) Name() string {
+	return util.SanitizeDNS1123Subdomain(fmt.Sprintf("%s-%s", c.moduleName, c.serviceName))
+}
+
+func (c *CronJob) Get(ctx context.Context, deps KubeContext) (*batchv1.CronJob, error) {
+	return deps.GetClientset().BatchV1().CronJobs(namespace(deps)).Get(ctx, c.Name(), metav1.GetOptions{})
+}
+
+func (c *CronJob) Patch(ctx context.Context, deps KubeContext, patchType types.PatchType, data []byte) (*batchv1.CronJob, error) {
+	return deps.GetClientset().BatchV1().CronJobs(namespace(deps)).Patch(ctx, c.Name(), patchType, data, metav1.PatchOptions{})
+}
+
+func (c *CronJob) Delete(ctx context.Context, deps KubeContext) error {
+	return deps.GetClientset().BatchV1().CronJobs(namespace(deps)).Delete(ctx, c.Name(), metav1.DeleteOptions{
+		PropagationPolicy: &deleteJobPods,
+	})
+}
+
+func (c *CronJob) build(deps KubeContext) *batchv1apply.CronJobApplyConfiguration {
+	labels := matchLabels(deps, map[string]string{
+		"mora.wingman": "false",
+	})
+
+	return batchv1apply.CronJob(c.Name(), namespace(deps)).
+		WithLabels(labels).
+		WithSpec(batchv1apply.CronJobSpec().
+			WithSchedule(c.schedule).
+			// a run that takes longer than the schedule shouldn't pile up
+			WithConcurrencyPolicy(batchv1.ForbidConcurrent).
+			WithJobTemplate(batchv1apply.JobTemplateSpec().
+				WithLabels(labels).
+				WithSpec(jobSpec(c.Name(), c.pod, labels))))
+}
+
+func (c *CronJob) Apply(ctx context.Context, deps KubeContext, dryRun bool) (*batchv1.CronJob, error) {
+	if err := checkSidecars(deps, c.pod); err != nil {
+		return nil, err
+	}
+
+	return apply(ctx, deps.GetClientset().BatchV1().CronJobs(namespace(deps)), c.build(deps), dryRun)
+}
+
+func (c *CronJob) Watch(ctx context.Context, deps KubeContext, opts metav1.ListOptions) (watch.Interface, error) {
+	return deps.GetClientset().BatchV1().CronJobs(namespace(deps)).Watch(ctx, opts)
+}
+
+// nothing runs until the schedule says so, so there isn't anything to wait on
+func (c *CronJob) Ready(cronJob *batchv1.CronJob) bool {
+	return true
+}
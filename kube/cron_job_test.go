@@ -0,0 +1,22 @@
+package kube
+
+import (
+	"testing"
+
+	"github.com/BSFishy/mora-manager/def"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCronJobRunsOnItsSchedule(t *testing.T) {
+	deps := &testContext{moduleName: "app", serviceName: "cleanup"}
+	cronJob := NewCronJob(deps, "0 3 * * *", def.Pod{Image: "app:latest"}).(*CronJob).build(deps)
+
+	if cronJob.Spec.Schedule == nil || *cronJob.Spec.Schedule != "0 3 * * *" {
+		t.Errorf("expected schedule 0 3 * * *, got %v", cronJob.Spec.Schedule)
+	}
+
+	pod := cronJob.Spec.JobTemplate.Spec.Template.Spec
+	if pod.RestartPolicy == nil || *pod.RestartPolicy != corev1.RestartPolicyNever {
+		t.Errorf("expected failed pods to be kept, got restart policy %v", pod.RestartPolicy)
+	}
+}
@@ -3,24 +3,24 @@ package kube
 import (
 	"context"
 	"fmt"
-	"slices"
+	"strconv"
 
 	"github.com/BSFishy/mora-manager/core"
 	"github.com/BSFishy/mora-manager/def"
 	"github.com/BSFishy/mora-manager/util"
-	"github.com/BSFishy/mora-manager/value"
 	appsv1 "k8s.io/api/apps/v1"
-	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	appsv1apply "k8s.io/client-go/applyconfigurations/apps/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	metav1apply "k8s.io/client-go/applyconfigurations/meta/v1"
 )
 
-// TODO: need to make a new module so that i can pass a ServiceDefinition around
 type Deployment struct {
 	moduleName  string
 	serviceName string
-	image       string
-	command     []string
-	env         []def.Env
+	pod         def.Pod
 	isWingman   bool
 
 	serviceAccount string
@@ -29,7 +29,7 @@ type Deployment struct {
 func NewDeployment(deps interface {
 	core.HasModuleName
 	core.HasServiceName
-}, image string, command []string, env []def.Env, isWingman bool, serviceAccount string,
+}, pod def.Pod, isWingman bool, serviceAccount string,
 ) Resource[appsv1.Deployment] {
 	moduleName := deps.GetModuleName()
 	serviceName := deps.GetServiceName()
@@ -37,9 +37,7 @@ func NewDeployment(deps interface {
 	return &Deployment{
 		moduleName:     moduleName,
 		serviceName:    serviceName,
-		image:          image,
-		command:        command,
-		env:            env,
+		pod:            pod,
 		isWingman:      isWingman,
 		serviceAccount: serviceAccount,
 	}
@@ -58,61 +56,15 @@ func (d *Deployment) Get(ctx context.Context, deps KubeContext) (*appsv1.Deploym
 	return deps.GetClientset().AppsV1().Deployments(namespace(deps)).Get(ctx, d.Name(), metav1.GetOptions{})
 }
 
-func (d *Deployment) IsValid(ctx context.Context, deployment *appsv1.Deployment) (bool, error) {
-	if d.serviceAccount != "" && deployment.Spec.Template.Spec.ServiceAccountName != d.serviceAccount {
-		return false, nil
-	}
-
-	containers := deployment.Spec.Template.Spec.Containers
-	if len(containers) != 1 {
-		return false, nil
-	}
-
-	container := containers[0]
-	if container.Image != d.image {
-		return false, nil
-	}
-
-	if len(d.command) > 0 && !slices.Equal(container.Command, d.command) {
-		return false, nil
-	}
-
-	if len(container.Env) != len(d.env) {
-		return false, nil
-	}
-
-	for _, env := range d.env {
-		found := false
-		for _, ce := range container.Env {
-			if ce.Name == env.Name {
-				if env.Value.Kind() == value.Secret {
-					if ce.ValueFrom == nil || ce.ValueFrom.SecretKeyRef == nil || ce.ValueFrom.SecretKeyRef.LocalObjectReference.Name != env.Value.String() {
-						return false, nil
-					}
-				} else {
-					if ce.Value != env.Value.String() {
-						return false, nil
-					}
-				}
-
-				found = true
-				break
-			}
-		}
-
-		if !found {
-			return false, nil
-		}
-	}
-
-	return true, nil
+func (d *Deployment) Patch(ctx context.Context, deps KubeContext, patchType types.PatchType, data []byte) (*appsv1.Deployment, error) {
+	return deps.GetClientset().AppsV1().Deployments(namespace(deps)).Patch(ctx, d.Name(), patchType, data, metav1.PatchOptions{})
 }
 
 func (d *Deployment) Delete(ctx context.Context, deps KubeContext) error {
 	return deps.GetClientset().AppsV1().Deployments(namespace(deps)).Delete(ctx, d.Name(), metav1.DeleteOptions{})
 }
 
-func (d *Deployment) Create(ctx context.Context, deps KubeContext) (*appsv1.Deployment, error) {
+func (d *Deployment) build(deps KubeContext) *appsv1apply.DeploymentApplyConfiguration {
 	extras := map[string]string{}
 	if d.isWingman {
 		extras["mora.wingman"] = "true"
@@ -120,67 +72,68 @@ func (d *Deployment) Create(ctx context.Context, deps KubeContext) (*appsv1.Depl
 		extras["mora.wingman"] = "false"
 	}
 
-	env := make([]corev1.EnvVar, len(d.env))
-	for i, e := range d.env {
-		if e.Value.Kind() == value.Secret {
-			env[i] = corev1.EnvVar{
-				Name: e.Name,
-				ValueFrom: &corev1.EnvVarSource{
-					SecretKeyRef: &corev1.SecretKeySelector{
-						LocalObjectReference: corev1.LocalObjectReference{
-							Name: e.Value.String(),
-						},
-						Key: "value",
-					},
-				},
-			}
-		} else {
-			env[i] = corev1.EnvVar{
-				Name:  e.Name,
-				Value: e.Value.String(),
-			}
-		}
+	labels := matchLabels(deps, extras)
+	spec := appsv1apply.DeploymentSpec().
+		WithSelector(metav1apply.LabelSelector().
+			WithMatchLabels(labels)).
+		// updates replace pods gradually so there's no downtime
+		WithStrategy(appsv1apply.DeploymentStrategy().
+			WithType(appsv1.RollingUpdateDeploymentStrategyType)).
+		WithTemplate(corev1apply.PodTemplateSpec().
+			WithLabels(labels).
+			WithAnnotations(podAnnotations(d.pod)).
+			WithSpec(podSpec(d.Name(), d.pod, d.serviceAccount)))
+
+	// left to whoever scales the deployment when the service doesn't say
+	if d.pod.Scheduling.Replicas != nil {
+		spec.WithReplicas(*d.pod.Scheduling.Replicas)
 	}
 
-	labels := matchLabels(deps, extras)
-	deployment := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Namespace: namespace(deps),
-			Name:      d.Name(),
-		},
-		Spec: appsv1.DeploymentSpec{
-			Selector: &metav1.LabelSelector{
-				MatchLabels: labels,
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Namespace: namespace(deps),
-					Name:      d.Name(),
-					Labels:    labels,
-				},
-				Spec: corev1.PodSpec{
-					ServiceAccountName: d.serviceAccount,
-					Containers: []corev1.Container{
-						{
-							Name:    util.SanitizeDNS1123Label(d.Name()),
-							Image:   d.image,
-							Command: d.command,
-							Env:     env,
-						},
-					},
-				},
-			},
-		},
+	return appsv1apply.Deployment(d.Name(), namespace(deps)).
+		WithLabels(labels).
+		WithSpec(spec)
+}
+
+func (d *Deployment) Apply(ctx context.Context, deps KubeContext, dryRun bool) (*appsv1.Deployment, error) {
+	if err := checkSidecars(deps, d.pod); err != nil {
+		return nil, err
 	}
 
-	return deps.GetClientset().AppsV1().Deployments(namespace(deps)).Create(ctx, deployment, metav1.CreateOptions{})
+	return apply(ctx, deps.GetClientset().AppsV1().Deployments(namespace(deps)), d.build(deps), dryRun)
+}
+
+func (d *Deployment) Watch(ctx context.Context, deps KubeContext, opts metav1.ListOptions) (watch.Interface, error) {
+	return deps.GetClientset().AppsV1().Deployments(namespace(deps)).Watch(ctx, opts)
+}
+
+func (d *Deployment) Diagnose(ctx context.Context, deps KubeContext) (*Diagnostics, error) {
+	return diagnosePods(ctx, deps, def.KindDeployment, d.Name(), matchLabels(deps, map[string]string{
+		"mora.wingman": strconv.FormatBool(d.isWingman),
+	}))
 }
 
+// Ready checks whether the latest rollout finished, the same way that kubectl
+// rollout status does
 func (d *Deployment) Ready(deployment *appsv1.Deployment) bool {
+	// the controller hasn't seen the latest spec yet, so the status is stale
+	if deployment.Status.ObservedGeneration < deployment.Generation {
+		return false
+	}
+
 	replicas := int32(1)
 	if deployment.Spec.Replicas != nil {
 		replicas = *deployment.Spec.Replicas
 	}
 
-	return deployment.Status.ReadyReplicas == replicas
+	status := deployment.Status
+	if status.UpdatedReplicas < replicas {
+		return false
+	}
+
+	// old pods are still being terminated
+	if status.Replicas > status.UpdatedReplicas {
+		return false
+	}
+
+	return status.AvailableReplicas >= status.UpdatedReplicas
 }
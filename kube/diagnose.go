@@ -0,0 +1,270 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BSFishy/mora-manager/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// how many log lines are kept for each container
+	diagnosticLogLines = 20
+	// how many of the most recent events are kept
+	diagnosticEvents = 10
+	// how many pods get looked at in detail. replicas tend to fail the same way
+	diagnosticPods = 3
+)
+
+// waiting reasons that are part of a pod starting normally
+var startingReasons = map[string]bool{
+	"ContainerCreating": true,
+	"PodInitializing":   true,
+}
+
+// Diagnostics explain why a resource didn't become ready
+type Diagnostics struct {
+	// short summaries of everything that looks wrong
+	Reasons []string          `json:"reasons"`
+	Pods    []PodDiagnostics  `json:"pods,omitempty"`
+	Events  []EventDiagnostic `json:"events,omitempty"`
+}
+
+type PodDiagnostics struct {
+	Name       string                 `json:"name"`
+	Phase      string                 `json:"phase"`
+	Containers []ContainerDiagnostics `json:"containers"`
+}
+
+type ContainerDiagnostics struct {
+	Name     string `json:"name"`
+	Ready    bool   `json:"ready"`
+	Restarts int32  `json:"restarts"`
+	// why the container isn't running, like ImagePullBackOff
+	Reason string `json:"reason,omitempty"`
+	// the last lines the container logged. if it crashed, this is the output of
+	// the crashed run
+	Logs []string `json:"logs,omitempty"`
+}
+
+type EventDiagnostic struct {
+	Type     string    `json:"type"`
+	Object   string    `json:"object"`
+	Reason   string    `json:"reason"`
+	Message  string    `json:"message"`
+	Count    int32     `json:"count"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// diagnosePods explains why the pods matching the selector aren't ready.
+// owner is the resource that owns the pods, whose events are included too
+func diagnosePods(ctx context.Context, deps KubeContext, ownerKind, owner string, selector map[string]string) (*Diagnostics, error) {
+	clientset := deps.GetClientset()
+	ns := namespace(deps)
+
+	opts := metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector).String(),
+	}
+
+	pods, err := clientset.CoreV1().Pods(ns).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("listing pods: %w", err)
+	}
+
+	involved := []corev1.ObjectReference{
+		{Kind: ownerKind, Name: owner},
+	}
+
+	// replica sets copy the pod labels, and they're the ones that complain
+	// about pods that can't even be created
+	replicaSets, err := clientset.AppsV1().ReplicaSets(ns).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("listing replica sets: %w", err)
+	}
+
+	for _, rs := range replicaSets.Items {
+		involved = append(involved, corev1.ObjectReference{Kind: "ReplicaSet", Name: rs.Name})
+	}
+
+	// stateful sets can be stuck waiting for their volumes to be provisioned
+	claims, err := clientset.CoreV1().PersistentVolumeClaims(ns).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("listing persistent volume claims: %w", err)
+	}
+
+	for _, claim := range claims.Items {
+		involved = append(involved, corev1.ObjectReference{Kind: "PersistentVolumeClaim", Name: claim.Name})
+	}
+
+	diagnostics := &Diagnostics{
+		Reasons: []string{},
+	}
+
+	if len(pods.Items) == 0 {
+		diagnostics.Reasons = append(diagnostics.Reasons, "no pods were created")
+	}
+
+	for _, pod := range pods.Items {
+		involved = append(involved, corev1.ObjectReference{Kind: "Pod", Name: pod.Name})
+
+		reasons := podReasons(pod)
+		diagnostics.Reasons = append(diagnostics.Reasons, reasons...)
+
+		if len(reasons) > 0 && len(diagnostics.Pods) < diagnosticPods {
+			diagnostics.Pods = append(diagnostics.Pods, diagnosePod(ctx, deps, pod))
+		}
+	}
+
+	// the api server filters events by a single object at a time, which beats
+	// going through every event in the namespace
+	for _, object := range involved {
+		events, err := clientset.CoreV1().Events(ns).List(ctx, metav1.ListOptions{
+			FieldSelector: fields.SelectorFromSet(fields.Set{
+				"involvedObject.kind": object.Kind,
+				"involvedObject.name": object.Name,
+			}).String(),
+		})
+		if err != nil {
+			return diagnostics, fmt.Errorf("listing events: %w", err)
+		}
+
+		for _, event := range events.Items {
+			lastSeen := event.LastTimestamp.Time
+			if lastSeen.IsZero() {
+				lastSeen = event.EventTime.Time
+			}
+
+			diagnostics.Events = append(diagnostics.Events, EventDiagnostic{
+				Type:     event.Type,
+				Object:   fmt.Sprintf("%s/%s", event.InvolvedObject.Kind, event.InvolvedObject.Name),
+				Reason:   event.Reason,
+				Message:  event.Message,
+				Count:    event.Count,
+				LastSeen: lastSeen,
+			})
+		}
+	}
+
+	slices.SortFunc(diagnostics.Events, func(a, b EventDiagnostic) int {
+		return a.LastSeen.Compare(b.LastSeen)
+	})
+
+	if len(diagnostics.Events) > diagnosticEvents {
+		diagnostics.Events = diagnostics.Events[len(diagnostics.Events)-diagnosticEvents:]
+	}
+
+	return diagnostics, nil
+}
+
+func diagnosePod(ctx context.Context, deps KubeContext, pod corev1.Pod) PodDiagnostics {
+	logger := util.LogFromCtx(ctx)
+
+	diagnostics := PodDiagnostics{
+		Name:       pod.Name,
+		Phase:      string(pod.Status.Phase),
+		Containers: []ContainerDiagnostics{},
+	}
+
+	statuses := append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		container := ContainerDiagnostics{
+			Name:     status.Name,
+			Ready:    status.Ready,
+			Restarts: status.RestartCount,
+			Reason:   containerReason(status),
+		}
+
+		// containers that never started don't have anything to say
+		if status.State.Running != nil || status.State.Terminated != nil || status.LastTerminationState.Terminated != nil {
+			logs, err := containerLogs(ctx, deps, pod.Name, status)
+			if err != nil {
+				logger.Warn("failed to get container logs", "pod", pod.Name, "container", status.Name, "err", err)
+			}
+
+			container.Logs = logs
+		}
+
+		diagnostics.Containers = append(diagnostics.Containers, container)
+	}
+
+	return diagnostics
+}
+
+func containerLogs(ctx context.Context, deps KubeContext, pod string, status corev1.ContainerStatus) ([]string, error) {
+	lines := int64(diagnosticLogLines)
+	opts := &corev1.PodLogOptions{
+		Container: status.Name,
+		TailLines: &lines,
+		// a crashing container is usually waiting to be restarted, so the useful
+		// output is from the run before
+		Previous: status.State.Running == nil && status.LastTerminationState.Terminated != nil,
+	}
+
+	raw, err := deps.GetClientset().CoreV1().Pods(namespace(deps)).GetLogs(pod, opts).DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logs := strings.TrimRight(string(raw), "\n")
+	if logs == "" {
+		return nil, nil
+	}
+
+	return strings.Split(logs, "\n"), nil
+}
+
+func podReasons(pod corev1.Pod) []string {
+	reasons := []string{}
+
+	for _, condition := range pod.Status.Conditions {
+		// scheduling problems are the only conditions that explain themselves.
+		// the rest just follow from the container statuses
+		if condition.Type == corev1.PodScheduled && condition.Status != corev1.ConditionTrue {
+			reasons = append(reasons, fmt.Sprintf("pod %s can't be scheduled: %s", pod.Name, condition.Message))
+		}
+	}
+
+	statuses := append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if reason := containerReason(status); reason != "" {
+			reasons = append(reasons, fmt.Sprintf("pod %s container %s: %s", pod.Name, status.Name, reason))
+		}
+	}
+
+	return reasons
+}
+
+func containerReason(status corev1.ContainerStatus) string {
+	if waiting := status.State.Waiting; waiting != nil && !startingReasons[waiting.Reason] {
+		reason := waiting.Reason
+		if waiting.Message != "" {
+			reason = fmt.Sprintf("%s: %s", reason, waiting.Message)
+		}
+
+		// a crash loop doesn't say anything about the crash itself
+		if last := status.LastTerminationState.Terminated; last != nil {
+			reason = fmt.Sprintf("%s (last exited with code %d: %s)", reason, last.ExitCode, last.Reason)
+		}
+
+		return reason
+	}
+
+	if terminated := status.State.Terminated; terminated != nil && terminated.ExitCode != 0 {
+		return fmt.Sprintf("exited with code %d: %s", terminated.ExitCode, terminated.Reason)
+	}
+
+	if status.State.Running != nil && !status.Ready {
+		return "running but not ready"
+	}
+
+	return ""
+}
@@ -0,0 +1,97 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/BSFishy/mora-manager/core"
+	"github.com/BSFishy/mora-manager/util"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	networkingv1apply "k8s.io/client-go/applyconfigurations/networking/v1"
+)
+
+type Ingress struct {
+	moduleName  string
+	serviceName string
+	host        string
+	path        string
+	tlsSecret   string
+	// the Service that traffic gets routed to and the port on it
+	backend string
+	port    int32
+}
+
+func NewIngress(deps interface {
+	core.HasModuleName
+	core.HasServiceName
+}, host, path, tlsSecret, backend string, port int32,
+) Resource[networkingv1.Ingress] {
+	return &Ingress{
+		moduleName:  deps.GetModuleName(),
+		serviceName: deps.GetServiceName(),
+		host:        host,
+		path:        path,
+		tlsSecret:   tlsSecret,
+		backend:     backend,
+		port:        port,
+	}
+}
+
+func (i *Ingress) Name() string {
+	return util.SanitizeDNS1123Subdomain(fmt.Sprintf("%s-%s", i.moduleName, i.serviceName))
+}
+
+func (i *Ingress) Get(ctx context.Context, deps KubeContext) (*networkingv1.Ingress, error) {
+	return deps.GetClientset().NetworkingV1().Ingresses(namespace(deps)).Get(ctx, i.Name(), metav1.GetOptions{})
+}
+
+func (i *Ingress) Patch(ctx context.Context, deps KubeContext, patchType types.PatchType, data []byte) (*networkingv1.Ingress, error) {
+	return deps.GetClientset().NetworkingV1().Ingresses(namespace(deps)).Patch(ctx, i.Name(), patchType, data, metav1.PatchOptions{})
+}
+
+func (i *Ingress) Delete(ctx context.Context, deps KubeContext) error {
+	return deps.GetClientset().NetworkingV1().Ingresses(namespace(deps)).Delete(ctx, i.Name(), metav1.DeleteOptions{})
+}
+
+func (i *Ingress) build(deps KubeContext) *networkingv1apply.IngressApplyConfiguration {
+	spec := networkingv1apply.IngressSpec().
+		WithRules(networkingv1apply.IngressRule().
+			WithHost(i.host).
+			WithHTTP(networkingv1apply.HTTPIngressRuleValue().
+				WithPaths(networkingv1apply.HTTPIngressPath().
+					WithPath(i.path).
+					WithPathType(networkingv1.PathTypePrefix).
+					WithBackend(networkingv1apply.IngressBackend().
+						WithService(networkingv1apply.IngressServiceBackend().
+							WithName(i.backend).
+							WithPort(networkingv1apply.ServiceBackendPort().
+								WithNumber(i.port)))))))
+
+	if i.tlsSecret != "" {
+		spec.WithTLS(networkingv1apply.IngressTLS().
+			WithHosts(i.host).
+			WithSecretName(i.tlsSecret))
+	}
+
+	return networkingv1apply.Ingress(i.Name(), namespace(deps)).
+		WithLabels(matchLabels(deps, nil)).
+		WithSpec(spec)
+}
+
+func (i *Ingress) Apply(ctx context.Context, deps KubeContext, dryRun bool) (*networkingv1.Ingress, error) {
+	return apply(ctx, deps.GetClientset().NetworkingV1().Ingresses(namespace(deps)), i.build(deps), dryRun)
+}
+
+func (i *Ingress) Watch(ctx context.Context, deps KubeContext, opts metav1.ListOptions) (watch.Interface, error) {
+	return deps.GetClientset().NetworkingV1().Ingresses(namespace(deps)).Watch(ctx, opts)
+}
+
+// the ingress controller is what eventually routes traffic, and not every
+// controller reports an address in the status. there isn't anything reliable
+// to wait on
+func (i *Ingress) Ready(ingress *networkingv1.Ingress) bool {
+	return true
+}
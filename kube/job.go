@@ -0,0 +1,124 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/BSFishy/mora-manager/core"
+	"github.com/BSFishy/mora-manager/def"
+	"github.com/BSFishy/mora-manager/util"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	batchv1apply "k8s.io/client-go/applyconfigurations/batch/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+// jobs orphan their pods when deleted unless told otherwise
+var deleteJobPods = metav1.DeletePropagationBackground
+
+// Job runs a service once until it succeeds, like a migration. once it has
+// completed, it isn't run again until it changes
+type Job struct {
+	moduleName  string
+	serviceName string
+	pod         def.Pod
+}
+
+func NewJob(deps interface {
+	core.HasModuleName
+	core.HasServiceName
+}, pod def.Pod,
+) Resource[batchv1.Job] {
+	return &Job{
+		moduleName:  deps.GetModuleName(),
+		serviceName: deps.GetServiceName(),
+		pod:         pod,
+	}
+}
+
+func (j *Job) Name() string {
+	return util.SanitizeDNS1123Subdomain(fmt.Sprintf("%s-%s", j.moduleName, j.serviceName))
+}
+
+func (j *Job) Get(ctx context.Context, deps KubeContext) (*batchv1.Job, error) {
+	return deps.GetClientset().BatchV1().Jobs(namespace(deps)).Get(ctx, j.Name(), metav1.GetOptions{})
+}
+
+func (j *Job) Patch(ctx context.Context, deps KubeContext, patchType types.PatchType, data []byte) (*batchv1.Job, error) {
+	return deps.GetClientset().BatchV1().Jobs(namespace(deps)).Patch(ctx, j.Name(), patchType, data, metav1.PatchOptions{})
+}
+
+func (j *Job) Delete(ctx context.Context, deps KubeContext) error {
+	return deps.GetClientset().BatchV1().Jobs(namespace(deps)).Delete(ctx, j.Name(), metav1.DeleteOptions{
+		PropagationPolicy: &deleteJobPods,
+	})
+}
+
+// the pod template of a job can't be changed, so applying a different one
+// gets the job recreated, which runs it again
+func (j *Job) build(deps KubeContext) *batchv1apply.JobApplyConfiguration {
+	labels := matchLabels(deps, map[string]string{
+		"mora.wingman": "false",
+	})
+
+	return batchv1apply.Job(j.Name(), namespace(deps)).
+		WithLabels(labels).
+		WithSpec(jobSpec(j.Name(), j.pod, labels))
+}
+
+// jobSpec builds the spec shared by jobs and the jobs of cron jobs
+func jobSpec(name string, pod def.Pod, labels map[string]string) *batchv1apply.JobSpecApplyConfiguration {
+	return batchv1apply.JobSpec().
+		WithTemplate(corev1apply.PodTemplateSpec().
+			WithLabels(labels).
+			WithAnnotations(podAnnotations(pod)).
+			WithSpec(podSpec(name, pod, "").
+				// failed pods are kept around so their logs can be looked at
+				WithRestartPolicy(corev1.RestartPolicyNever)))
+}
+
+func (j *Job) Apply(ctx context.Context, deps KubeContext, dryRun bool) (*batchv1.Job, error) {
+	if err := checkSidecars(deps, j.pod); err != nil {
+		return nil, err
+	}
+
+	return apply(ctx, deps.GetClientset().BatchV1().Jobs(namespace(deps)), j.build(deps), dryRun)
+}
+
+func (j *Job) Watch(ctx context.Context, deps KubeContext, opts metav1.ListOptions) (watch.Interface, error) {
+	return deps.GetClientset().BatchV1().Jobs(namespace(deps)).Watch(ctx, opts)
+}
+
+func (j *Job) Diagnose(ctx context.Context, deps KubeContext) (*Diagnostics, error) {
+	return diagnosePods(ctx, deps, def.KindJob, j.Name(), matchLabels(deps, map[string]string{
+		"mora.wingman": "false",
+	}))
+}
+
+// Ready checks whether the job completed successfully
+func (j *Job) Ready(job *batchv1.Job) bool {
+	return jobCondition(job, batchv1.JobComplete) != nil
+}
+
+// Failed checks whether the job ran out of retries
+func (j *Job) Failed(job *batchv1.Job) error {
+	condition := jobCondition(job, batchv1.JobFailed)
+	if condition == nil {
+		return nil
+	}
+
+	return fmt.Errorf("job failed: %s: %s", condition.Reason, condition.Message)
+}
+
+func jobCondition(job *batchv1.Job, conditionType batchv1.JobConditionType) *batchv1.JobCondition {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == corev1.ConditionTrue {
+			return &condition
+		}
+	}
+
+	return nil
+}
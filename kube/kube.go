@@ -2,86 +2,299 @@ package kube
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
 	"maps"
+	"strings"
 	"time"
 
 	"github.com/BSFishy/mora-manager/core"
+	"github.com/BSFishy/mora-manager/def"
 	"github.com/BSFishy/mora-manager/util"
-	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
 )
 
 type Resource[T any] interface {
 	Name() string
 	Get(context.Context, KubeContext) (*T, error)
-	IsValid(context.Context, *T) (bool, error)
+	Patch(ctx context.Context, deps KubeContext, patchType types.PatchType, data []byte) (*T, error)
 	Delete(context.Context, KubeContext) error
-	Create(context.Context, KubeContext) (*T, error)
+	// Apply server-side applies the resource, creating or updating it. changes
+	// that can't be made in place return an invalid error, and the resource gets
+	// recreated instead. a dry run only reports what the resource would become
+	Apply(ctx context.Context, deps KubeContext, dryRun bool) (*T, error)
+	// Watch watches resources in the namespace. the options select this one
+	Watch(context.Context, KubeContext, metav1.ListOptions) (watch.Interface, error)
 	Ready(*T) bool
 }
 
-func pollReady[T any](ctx context.Context, deps KubeContext, res Resource[T], value *T) error {
+// Diagnoser can be implemented by resources that can explain why they aren't
+// ready
+type Diagnoser interface {
+	Diagnose(context.Context, KubeContext) (*Diagnostics, error)
+}
+
+// Failer can be implemented by resources that can fail for good, like jobs.
+// there's no point waiting for those to become ready
+type Failer[T any] interface {
+	// Failed returns why the resource failed, or nil if it hasn't
+	Failed(*T) error
+}
+
+func failure[T any](res Resource[T], value *T) error {
+	if failer, ok := res.(Failer[T]); ok {
+		return failer.Failed(value)
+	}
+
+	return nil
+}
+
+// ReadinessError is returned by Deploy when a resource was deployed but didn't
+// become ready
+type ReadinessError struct {
+	Err error
+	// why the resource isn't ready, if it could be figured out
+	Diagnostics *Diagnostics
+}
+
+func (e *ReadinessError) Error() string {
+	if e.Diagnostics == nil || len(e.Diagnostics.Reasons) == 0 {
+		return fmt.Sprintf("waiting for readiness: %s", e.Err)
+	}
+
+	return fmt.Sprintf("waiting for readiness: %s (%s)", e.Err, strings.Join(e.Diagnostics.Reasons, "; "))
+}
+
+func (e *ReadinessError) Unwrap() error {
+	return e.Err
+}
+
+// waitReady watches the resource until it is ready. value is the latest known
+// version of the resource, which the watch starts from
+func waitReady[T any](ctx context.Context, deps KubeContext, res Resource[T], value *T) error {
+	logger := util.LogFromCtx(ctx)
+
 	for !res.Ready(value) {
-		util.LogFromCtx(ctx).Debug("waiting for resource to be ready")
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(500 * time.Millisecond):
+		if err := failure(res, value); err != nil {
+			return err
+		}
+
+		logger.Debug("waiting for resource to be ready")
+
+		accessor, err := meta.Accessor(value)
+		if err != nil {
+			return fmt.Errorf("reading resource metadata: %w", err)
 		}
 
-		var err error
-		value, err = res.Get(ctx, deps)
+		w, err := res.Watch(ctx, deps, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", res.Name()).String(),
+			ResourceVersion: accessor.GetResourceVersion(),
+		})
 		if err != nil {
-			return fmt.Errorf("re-fetching for readiness: %w", err)
+			return fmt.Errorf("watching resource: %w", err)
+		}
+
+		value, err = nextReady(ctx, res, w)
+		w.Stop()
+		if err != nil {
+			return err
+		}
+
+		// the watch ended without the resource becoming ready. the api server
+		// closes watches every now and then, so start over from the current
+		// version
+		if value == nil {
+			if value, err = res.Get(ctx, deps); err != nil {
+				return fmt.Errorf("re-fetching for readiness: %w", err)
+			}
 		}
 	}
 
 	return nil
 }
 
-func Deploy[T any](ctx context.Context, deps KubeContext, res Resource[T]) error {
-	ctx, cancel := context.WithTimeout(ctx, time.Minute)
-	defer cancel()
+// nextReady reads events until the resource is ready. returns nil if the watch
+// ends first
+func nextReady[T any](ctx context.Context, res Resource[T], w watch.Interface) (*T, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil, nil
+			}
+
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				value, ok := any(event.Object).(*T)
+				if !ok {
+					return nil, fmt.Errorf("unexpected watch object %T", event.Object)
+				}
+
+				if res.Ready(value) {
+					return value, nil
+				}
+
+				if err := failure(res, value); err != nil {
+					return nil, err
+				}
+			case watch.Deleted:
+				return nil, stderrors.New("resource was deleted while waiting for it")
+			case watch.Error:
+				// usually means the version we started from is too old
+				return nil, nil
+			}
+		}
+	}
+}
+
+// readinessError explains why a resource didn't become ready. the deadline has
+// usually passed by now, so diagnosing gets its own
+func readinessError[T any](ctx context.Context, deps KubeContext, res Resource[T], err error) error {
+	readinessErr := &ReadinessError{
+		Err: err,
+	}
+
+	if diagnoser, ok := res.(Diagnoser); ok && !stderrors.Is(err, context.Canceled) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+
+		diagnostics, diagErr := diagnoser.Diagnose(ctx, deps)
+		if diagErr != nil {
+			util.LogFromCtx(ctx).Warn("failed to diagnose resource", "err", diagErr)
+		}
+
+		readinessErr.Diagnostics = diagnostics
+	}
+
+	return readinessErr
+}
+
+// ResourceObserver can be implemented by the context passed to Deploy to hear
+// about the resources that it creates, updates or recreates
+type ResourceObserver interface {
+	ResourceDeployed(ctx context.Context, ref def.ResourceRef, action PlanAction)
+}
 
+func notifyObserver[T any](ctx context.Context, deps KubeContext, kind string, res Resource[T], action PlanAction) {
+	if observer, ok := deps.(ResourceObserver); ok {
+		observer.ResourceDeployed(ctx, def.ResourceRef{Kind: kind, Name: res.Name()}, action)
+	}
+}
+
+// resourceVersion reads the version that kubernetes bumps whenever the resource
+// is written
+func resourceVersion(value any) (string, error) {
+	accessor, err := meta.Accessor(value)
+	if err != nil {
+		return "", fmt.Errorf("reading resource metadata: %w", err)
+	}
+
+	return accessor.GetResourceVersion(), nil
+}
+
+// Deploy makes sure the resource exists as described and waits for it to be
+// ready. callers are expected to put a deadline on ctx
+func Deploy[T any](ctx context.Context, deps KubeContext, kind string, res Resource[T]) error {
+	logger := util.LogFromCtx(ctx)
+
+	action := PlanCreate
 	found, err := res.Get(ctx, deps)
 	if err == nil {
-		valid, err := res.IsValid(ctx, found)
+		action = PlanUnchanged
+
+		// something that failed for good won't recover on its own, so it gets
+		// another try by being recreated
+		if err = failure(res, found); err != nil {
+			logger.Info("resource failed before, recreating it", "err", err)
+			action = PlanRecreate
+		}
+	} else if !errors.IsNotFound(err) {
+		return fmt.Errorf("getting resource: %w", err)
+	}
+
+	if action == PlanUnchanged {
+		if found, err = upgradeManagedFields(ctx, deps, res, found); err != nil {
+			return err
+		}
+	}
+
+	var applied *T
+	if action != PlanRecreate {
+		applied, err = res.Apply(ctx, deps, false)
 		if err != nil {
-			return fmt.Errorf("checking validity: %w", err)
+			if action == PlanCreate || !errors.IsInvalid(err) {
+				return fmt.Errorf("applying resource: %w", err)
+			}
+
+			logger.Info("resource can't be updated in place, recreating it", "err", err)
+			action = PlanRecreate
 		}
+	}
 
-		if valid {
-			return pollReady(ctx, deps, res, found)
+	if action == PlanRecreate {
+		if err = deleteAndWait(ctx, deps, res); err != nil {
+			return err
 		}
 
-		if err = res.Delete(ctx, deps); err != nil {
-			return fmt.Errorf("deleting resource: %w", err)
+		if applied, err = res.Apply(ctx, deps, false); err != nil {
+			return fmt.Errorf("creating resource: %w", err)
 		}
+	}
 
-		for {
-			_, err = res.Get(ctx, deps)
-			if errors.IsNotFound(err) {
-				break
-			}
+	// applying something that's already there doesn't write anything, so the
+	// version only moves when the resource was actually updated
+	if action == PlanUnchanged {
+		before, err := resourceVersion(found)
+		if err != nil {
+			return err
+		}
 
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case <-time.After(500 * time.Millisecond):
-			}
+		after, err := resourceVersion(applied)
+		if err != nil {
+			return err
+		}
+
+		if before != after {
+			action = PlanUpdate
 		}
-	} else if !errors.IsNotFound(err) {
-		return fmt.Errorf("getting resource: %w", err)
 	}
 
-	created, err := res.Create(ctx, deps)
-	if err != nil {
-		return fmt.Errorf("creating resource: %w", err)
+	if action != PlanUnchanged {
+		notifyObserver(ctx, deps, kind, res, action)
+	}
+
+	if err = waitReady(ctx, deps, res, applied); err != nil {
+		return readinessError(ctx, deps, res, err)
 	}
 
-	return pollReady(ctx, deps, res, created)
+	return nil
+}
+
+func deleteAndWait[T any](ctx context.Context, deps KubeContext, res Resource[T]) error {
+	if err := res.Delete(ctx, deps); err != nil {
+		return fmt.Errorf("deleting resource: %w", err)
+	}
+
+	for {
+		_, err := res.Get(ctx, deps)
+		if errors.IsNotFound(err) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
 }
 
 func namespace(deps interface {
@@ -110,13 +323,7 @@ func EnsureNamespace(ctx context.Context, deps interface {
 	}
 
 	if errors.IsNotFound(err) {
-		config := corev1.Namespace{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: ns,
-			},
-		}
-
-		_, err := clientset.CoreV1().Namespaces().Create(ctx, &config, metav1.CreateOptions{})
+		_, err := apply(ctx, clientset.CoreV1().Namespaces(), corev1apply.Namespace(ns), false)
 		if err != nil {
 			return fmt.Errorf("creating namespace: %w", err)
 		}
@@ -0,0 +1,31 @@
+package kube
+
+import (
+	"k8s.io/client-go/kubernetes"
+)
+
+// testContext builds resources without a cluster behind it
+type testContext struct {
+	moduleName  string
+	serviceName string
+}
+
+func (t *testContext) GetClientset() kubernetes.Interface {
+	return nil
+}
+
+func (t *testContext) GetUser() string {
+	return "user"
+}
+
+func (t *testContext) GetEnvironment() string {
+	return "env"
+}
+
+func (t *testContext) GetModuleName() string {
+	return t.moduleName
+}
+
+func (t *testContext) GetServiceName() string {
+	return t.serviceName
+}
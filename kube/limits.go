@@ -0,0 +1,178 @@
+package kube
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"strconv"
+
+	"github.com/BSFishy/mora-manager/core"
+	"github.com/BSFishy/mora-manager/def"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	quotaName      = "mora-quota"
+	limitRangeName = "mora-limits"
+)
+
+// ValidateLimits checks that the limits can be enforced. a cpu or memory quota
+// makes kubernetes reject containers without limits, so those quotas need a
+// default to go with them
+func ValidateLimits(limits def.Limits) error {
+	quantities := []struct {
+		name     string
+		quantity string
+	}{
+		{"cpu", limits.Cpu},
+		{"memory", limits.Memory},
+		{"default cpu", limits.DefaultCpu},
+		{"default memory", limits.DefaultMemory},
+	}
+
+	for _, q := range quantities {
+		if q.quantity == "" {
+			continue
+		}
+
+		if _, err := resource.ParseQuantity(q.quantity); err != nil {
+			return fmt.Errorf("invalid %s %s: %w", q.name, q.quantity, err)
+		}
+	}
+
+	if limits.Pods != "" {
+		pods, err := strconv.Atoi(limits.Pods)
+		if err != nil || pods < 0 {
+			return fmt.Errorf("invalid pods %s", limits.Pods)
+		}
+	}
+
+	if limits.Cpu != "" && limits.DefaultCpu == "" {
+		return stderrors.New("a cpu quota needs a default cpu limit")
+	}
+
+	if limits.Memory != "" && limits.DefaultMemory == "" {
+		return stderrors.New("a memory quota needs a default memory limit")
+	}
+
+	return nil
+}
+
+// ReconcileLimits makes the namespace's resource quota and limit range match
+// the environment's limits. the namespace has to exist already
+func ReconcileLimits(ctx context.Context, deps interface {
+	core.HasUser
+	core.HasEnvironment
+	core.HasClientSet
+}, limits def.Limits,
+) error {
+	clientset := deps.GetClientset()
+	ns := namespace(deps)
+
+	if err := reconcileQuota(ctx, clientset, ns, limits); err != nil {
+		return fmt.Errorf("reconciling resource quota: %w", err)
+	}
+
+	if err := reconcileLimitRange(ctx, clientset, ns, limits); err != nil {
+		return fmt.Errorf("reconciling limit range: %w", err)
+	}
+
+	return nil
+}
+
+func reconcileQuota(ctx context.Context, clientset kubernetes.Interface, ns string, limits def.Limits) error {
+	client := clientset.CoreV1().ResourceQuotas(ns)
+
+	// the limits were validated before they were saved, but they come back out
+	// of the database, so they're parsed carefully anyway
+	hard := corev1.ResourceList{}
+	if limits.Cpu != "" {
+		cpu, err := resource.ParseQuantity(limits.Cpu)
+		if err != nil {
+			return fmt.Errorf("invalid cpu %s: %w", limits.Cpu, err)
+		}
+
+		hard[corev1.ResourceRequestsCPU] = cpu
+		hard[corev1.ResourceLimitsCPU] = cpu
+	}
+
+	if limits.Memory != "" {
+		memory, err := resource.ParseQuantity(limits.Memory)
+		if err != nil {
+			return fmt.Errorf("invalid memory %s: %w", limits.Memory, err)
+		}
+
+		hard[corev1.ResourceRequestsMemory] = memory
+		hard[corev1.ResourceLimitsMemory] = memory
+	}
+
+	if limits.Pods != "" {
+		pods, err := resource.ParseQuantity(limits.Pods)
+		if err != nil {
+			return fmt.Errorf("invalid pods %s: %w", limits.Pods, err)
+		}
+
+		hard[corev1.ResourcePods] = pods
+	}
+
+	if len(hard) == 0 {
+		err := client.Delete(ctx, quotaName, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+
+		return nil
+	}
+
+	_, err := apply(ctx, client, corev1apply.ResourceQuota(quotaName, ns).
+		WithSpec(corev1apply.ResourceQuotaSpec().
+			WithHard(hard)), false)
+
+	return err
+}
+
+func reconcileLimitRange(ctx context.Context, clientset kubernetes.Interface, ns string, limits def.Limits) error {
+	client := clientset.CoreV1().LimitRanges(ns)
+
+	defaults := corev1.ResourceList{}
+	if limits.DefaultCpu != "" {
+		cpu, err := resource.ParseQuantity(limits.DefaultCpu)
+		if err != nil {
+			return fmt.Errorf("invalid default cpu %s: %w", limits.DefaultCpu, err)
+		}
+
+		defaults[corev1.ResourceCPU] = cpu
+	}
+
+	if limits.DefaultMemory != "" {
+		memory, err := resource.ParseQuantity(limits.DefaultMemory)
+		if err != nil {
+			return fmt.Errorf("invalid default memory %s: %w", limits.DefaultMemory, err)
+		}
+
+		defaults[corev1.ResourceMemory] = memory
+	}
+
+	if len(defaults) == 0 {
+		err := client.Delete(ctx, limitRangeName, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+
+		return nil
+	}
+
+	_, err := apply(ctx, client, corev1apply.LimitRange(limitRangeName, ns).
+		WithSpec(corev1apply.LimitRangeSpec().
+			WithLimits(corev1apply.LimitRangeItem().
+				// kubernetes defaults the requests to these too
+				WithType(corev1.LimitTypeContainer).
+				WithDefault(defaults))), false)
+
+	return err
+}
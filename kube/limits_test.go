@@ -0,0 +1,39 @@
+package kube
+
+import (
+	"testing"
+
+	"github.com/BSFishy/mora-manager/def"
+)
+
+func TestValidateLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits def.Limits
+		valid  bool
+	}{
+		{"none", def.Limits{}, true},
+		{"everything", def.Limits{Cpu: "4", Memory: "8Gi", Pods: "20", DefaultCpu: "500m", DefaultMemory: "512Mi"}, true},
+		{"only defaults", def.Limits{DefaultCpu: "250m", DefaultMemory: "256Mi"}, true},
+		{"only pods", def.Limits{Pods: "0"}, true},
+		{"invalid cpu", def.Limits{Cpu: "lots", DefaultCpu: "500m"}, false},
+		{"invalid default memory", def.Limits{DefaultMemory: "512MB"}, false},
+		{"invalid pods", def.Limits{Pods: "1.5"}, false},
+		{"negative pods", def.Limits{Pods: "-1"}, false},
+		{"cpu quota without a default", def.Limits{Cpu: "4"}, false},
+		{"memory quota without a default", def.Limits{Memory: "8Gi"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateLimits(tt.limits)
+			if tt.valid && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+
+			if !tt.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -2,56 +2,186 @@ package kube
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/BSFishy/mora-manager/def"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 )
 
 type MaterializedService struct {
-	Deployments []Resource[appsv1.Deployment]
-	Services    []Resource[corev1.Service]
-	Secrets     []Resource[corev1.Secret]
+	Deployments  []Resource[appsv1.Deployment]
+	StatefulSets []Resource[appsv1.StatefulSet]
+	Jobs         []Resource[batchv1.Job]
+	CronJobs     []Resource[batchv1.CronJob]
+	Services     []Resource[corev1.Service]
+	Secrets      []Resource[corev1.Secret]
+	ConfigMaps   []Resource[corev1.ConfigMap]
+	Ingresses    []Resource[networkingv1.Ingress]
+	// only set when the environment isolates its services
+	NetworkPolicies []Resource[networkingv1.NetworkPolicy]
 
 	Roles           []Resource[rbacv1.Role]
 	RoleBindings    []Resource[rbacv1.RoleBinding]
 	ServiceAccounts []Resource[corev1.ServiceAccount]
+
+	// resources that are used by this service but aren't deployed as part of it,
+	// like secrets created by a wingman. these are kept around when pruning
+	References []def.ResourceRef
 }
 
 func (m *MaterializedService) Deploy(ctx context.Context, deps KubeContext) error {
-	if err := deployAll(ctx, deps, m.Roles); err != nil {
+	if err := deployAll(ctx, deps, def.KindRole, m.Roles); err != nil {
+		return err
+	}
+
+	if err := deployAll(ctx, deps, def.KindRoleBinding, m.RoleBindings); err != nil {
+		return err
+	}
+
+	if err := deployAll(ctx, deps, def.KindServiceAccount, m.ServiceAccounts); err != nil {
+		return err
+	}
+
+	if err := deployAll(ctx, deps, def.KindSecret, m.Secrets); err != nil {
+		return err
+	}
+
+	if err := deployAll(ctx, deps, def.KindConfigMap, m.ConfigMaps); err != nil {
+		return err
+	}
+
+	// policies go in before the pods they protect
+	if err := deployAll(ctx, deps, def.KindNetworkPolicy, m.NetworkPolicies); err != nil {
 		return err
 	}
 
-	if err := deployAll(ctx, deps, m.RoleBindings); err != nil {
+	if err := deployAll(ctx, deps, def.KindDeployment, m.Deployments); err != nil {
 		return err
 	}
 
-	if err := deployAll(ctx, deps, m.ServiceAccounts); err != nil {
+	if err := deployAll(ctx, deps, def.KindStatefulSet, m.StatefulSets); err != nil {
 		return err
 	}
 
-	if err := deployAll(ctx, deps, m.Secrets); err != nil {
+	if err := deployAll(ctx, deps, def.KindJob, m.Jobs); err != nil {
 		return err
 	}
 
-	if err := deployAll(ctx, deps, m.Deployments); err != nil {
+	if err := deployAll(ctx, deps, def.KindCronJob, m.CronJobs); err != nil {
 		return err
 	}
 
-	if err := deployAll(ctx, deps, m.Services); err != nil {
+	if err := deployAll(ctx, deps, def.KindService, m.Services); err != nil {
+		return err
+	}
+
+	if err := deployAll(ctx, deps, def.KindIngress, m.Ingresses); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func deployAll[T any](ctx context.Context, deps KubeContext, resources []Resource[T]) error {
+func deployAll[T any](ctx context.Context, deps KubeContext, kind string, resources []Resource[T]) error {
 	for _, res := range resources {
-		if err := Deploy(ctx, deps, res); err != nil {
-			return err
+		if err := Deploy(ctx, deps, kind, res); err != nil {
+			return fmt.Errorf("deploying %s %s: %w", kind, res.Name(), err)
 		}
 	}
 
 	return nil
 }
+
+// Plan reports what Deploy would do for each resource, in the same order that
+// Deploy would do it
+func (m *MaterializedService) Plan(ctx context.Context, deps KubeContext) ([]ResourcePlan, error) {
+	plans := []ResourcePlan{}
+
+	var err error
+	if plans, err = planAll(ctx, deps, plans, def.KindRole, m.Roles); err != nil {
+		return nil, err
+	}
+
+	if plans, err = planAll(ctx, deps, plans, def.KindRoleBinding, m.RoleBindings); err != nil {
+		return nil, err
+	}
+
+	if plans, err = planAll(ctx, deps, plans, def.KindServiceAccount, m.ServiceAccounts); err != nil {
+		return nil, err
+	}
+
+	if plans, err = planAll(ctx, deps, plans, def.KindSecret, m.Secrets); err != nil {
+		return nil, err
+	}
+
+	if plans, err = planAll(ctx, deps, plans, def.KindConfigMap, m.ConfigMaps); err != nil {
+		return nil, err
+	}
+
+	if plans, err = planAll(ctx, deps, plans, def.KindNetworkPolicy, m.NetworkPolicies); err != nil {
+		return nil, err
+	}
+
+	if plans, err = planAll(ctx, deps, plans, def.KindDeployment, m.Deployments); err != nil {
+		return nil, err
+	}
+
+	if plans, err = planAll(ctx, deps, plans, def.KindStatefulSet, m.StatefulSets); err != nil {
+		return nil, err
+	}
+
+	if plans, err = planAll(ctx, deps, plans, def.KindJob, m.Jobs); err != nil {
+		return nil, err
+	}
+
+	if plans, err = planAll(ctx, deps, plans, def.KindCronJob, m.CronJobs); err != nil {
+		return nil, err
+	}
+
+	if plans, err = planAll(ctx, deps, plans, def.KindService, m.Services); err != nil {
+		return nil, err
+	}
+
+	if plans, err = planAll(ctx, deps, plans, def.KindIngress, m.Ingresses); err != nil {
+		return nil, err
+	}
+
+	return plans, nil
+}
+
+func planAll[T any](ctx context.Context, deps KubeContext, plans []ResourcePlan, kind string, resources []Resource[T]) ([]ResourcePlan, error) {
+	for _, res := range resources {
+		plan, err := Plan(ctx, deps, kind, res)
+		if err != nil {
+			return nil, fmt.Errorf("planning %s %s: %w", kind, res.Name(), err)
+		}
+
+		plans = append(plans, plan)
+	}
+
+	return plans, nil
+}
+
+// Refs lists every resource this service is made of or uses
+func (m *MaterializedService) Refs() []def.ResourceRef {
+	result := []def.ResourceRef{}
+	result = refs(result, def.KindRole, m.Roles)
+	result = refs(result, def.KindRoleBinding, m.RoleBindings)
+	result = refs(result, def.KindServiceAccount, m.ServiceAccounts)
+	result = refs(result, def.KindSecret, m.Secrets)
+	result = refs(result, def.KindConfigMap, m.ConfigMaps)
+	result = refs(result, def.KindNetworkPolicy, m.NetworkPolicies)
+	result = refs(result, def.KindDeployment, m.Deployments)
+	result = refs(result, def.KindStatefulSet, m.StatefulSets)
+	result = refs(result, def.KindJob, m.Jobs)
+	result = refs(result, def.KindCronJob, m.CronJobs)
+	result = refs(result, def.KindService, m.Services)
+	result = refs(result, def.KindIngress, m.Ingresses)
+	result = append(result, m.References...)
+
+	return result
+}
@@ -0,0 +1,158 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/BSFishy/mora-manager/core"
+	"github.com/BSFishy/mora-manager/def"
+	"github.com/BSFishy/mora-manager/util"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/watch"
+	metav1apply "k8s.io/client-go/applyconfigurations/meta/v1"
+	networkingv1apply "k8s.io/client-go/applyconfigurations/networking/v1"
+)
+
+// NetworkPeer is a service that is allowed to connect to another
+type NetworkPeer struct {
+	Module  string
+	Service string
+}
+
+// NetworkPolicy only lets traffic into a service's pods from the services that
+// depend on it. the service's own pods, including its wingman, can always
+// reach each other
+type NetworkPolicy struct {
+	moduleName  string
+	serviceName string
+	dependents  []NetworkPeer
+	// ports that are exposed through an ingress, which are open to everything
+	exposed []def.Port
+}
+
+func NewNetworkPolicy(deps interface {
+	core.HasModuleName
+	core.HasServiceName
+}, dependents []NetworkPeer, exposed []def.Port,
+) Resource[networkingv1.NetworkPolicy] {
+	return &NetworkPolicy{
+		moduleName:  deps.GetModuleName(),
+		serviceName: deps.GetServiceName(),
+		dependents:  dependents,
+		exposed:     exposed,
+	}
+}
+
+func (n *NetworkPolicy) Name() string {
+	return util.SanitizeDNS1123Subdomain(fmt.Sprintf("%s-%s", n.moduleName, n.serviceName))
+}
+
+func (n *NetworkPolicy) Get(ctx context.Context, deps KubeContext) (*networkingv1.NetworkPolicy, error) {
+	return deps.GetClientset().NetworkingV1().NetworkPolicies(namespace(deps)).Get(ctx, n.Name(), metav1.GetOptions{})
+}
+
+func (n *NetworkPolicy) Patch(ctx context.Context, deps KubeContext, patchType types.PatchType, data []byte) (*networkingv1.NetworkPolicy, error) {
+	return deps.GetClientset().NetworkingV1().NetworkPolicies(namespace(deps)).Patch(ctx, n.Name(), patchType, data, metav1.PatchOptions{})
+}
+
+func (n *NetworkPolicy) Delete(ctx context.Context, deps KubeContext) error {
+	return deps.GetClientset().NetworkingV1().NetworkPolicies(namespace(deps)).Delete(ctx, n.Name(), metav1.DeleteOptions{})
+}
+
+func servicePeer(peer NetworkPeer) *networkingv1apply.NetworkPolicyPeerApplyConfiguration {
+	// no wingman label, so the peer's wingman is let in too
+	return networkingv1apply.NetworkPolicyPeer().
+		WithPodSelector(metav1apply.LabelSelector().
+			WithMatchLabels(map[string]string{
+				"mora.module":  peer.Module,
+				"mora.service": peer.Service,
+			}))
+}
+
+func (n *NetworkPolicy) spec() *networkingv1apply.NetworkPolicySpecApplyConfiguration {
+	from := networkingv1apply.NetworkPolicyIngressRule().
+		WithFrom(servicePeer(NetworkPeer{Module: n.moduleName, Service: n.serviceName}))
+
+	for _, dependent := range n.dependents {
+		from.WithFrom(servicePeer(dependent))
+	}
+
+	spec := networkingv1apply.NetworkPolicySpec().
+		WithPodSelector(metav1apply.LabelSelector().
+			WithMatchLabels(map[string]string{
+				"mora.module":  n.moduleName,
+				"mora.service": n.serviceName,
+				"mora.wingman": "false",
+			})).
+		WithIngress(from).
+		WithPolicyTypes(networkingv1.PolicyTypeIngress)
+
+	// the ingress controller could be anywhere, so exposed ports accept
+	// everything
+	if len(n.exposed) > 0 {
+		exposed := networkingv1apply.NetworkPolicyIngressRule()
+		for _, p := range n.exposed {
+			protocol := corev1.Protocol(p.Protocol)
+			if protocol == "" {
+				protocol = corev1.ProtocolTCP
+			}
+
+			exposed.WithPorts(networkingv1apply.NetworkPolicyPort().
+				WithProtocol(protocol).
+				WithPort(intstr.FromInt32(p.TargetPort)))
+		}
+
+		spec.WithIngress(exposed)
+	}
+
+	return spec
+}
+
+func (n *NetworkPolicy) build(deps KubeContext) *networkingv1apply.NetworkPolicyApplyConfiguration {
+	return networkingv1apply.NetworkPolicy(n.Name(), namespace(deps)).
+		WithLabels(matchLabels(deps, nil)).
+		WithSpec(n.spec())
+}
+
+func (n *NetworkPolicy) Apply(ctx context.Context, deps KubeContext, dryRun bool) (*networkingv1.NetworkPolicy, error) {
+	return apply(ctx, deps.GetClientset().NetworkingV1().NetworkPolicies(namespace(deps)), n.build(deps), dryRun)
+}
+
+func (n *NetworkPolicy) Watch(ctx context.Context, deps KubeContext, opts metav1.ListOptions) (watch.Interface, error) {
+	return deps.GetClientset().NetworkingV1().NetworkPolicies(namespace(deps)).Watch(ctx, opts)
+}
+
+// policies are enforced by the network plugin as soon as they exist
+func (n *NetworkPolicy) Ready(policy *networkingv1.NetworkPolicy) bool {
+	return true
+}
+
+// DeleteNetworkPolicies deletes every network policy that mora created in the
+// environment's namespace, opening its services back up
+func DeleteNetworkPolicies(ctx context.Context, deps interface {
+	core.HasClientSet
+	core.HasUser
+	core.HasEnvironment
+},
+) error {
+	opts := metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{
+			"mora.enabled":     "true",
+			"mora.user":        deps.GetUser(),
+			"mora.environment": deps.GetEnvironment(),
+		}).String(),
+	}
+
+	err := deps.GetClientset().NetworkingV1().NetworkPolicies(namespace(deps)).DeleteCollection(ctx, metav1.DeleteOptions{}, opts)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
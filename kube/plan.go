@@ -0,0 +1,76 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+type PlanAction string
+
+const (
+	PlanCreate    PlanAction = "create"
+	PlanUnchanged PlanAction = "unchanged"
+	PlanUpdate    PlanAction = "update"
+	// only when a resource can't be updated in place
+	PlanRecreate PlanAction = "recreate"
+	// managed resources that aren't part of the config anymore, when the
+	// environment prunes
+	PlanDelete PlanAction = "delete"
+)
+
+type ResourcePlan struct {
+	Kind   string     `json:"kind"`
+	Name   string     `json:"name"`
+	Action PlanAction `json:"action"`
+}
+
+// Plan figures out what Deploy would do with a resource without changing
+// anything in the cluster. the resource is applied as a dry run, so kubernetes
+// decides what would change the same way it would for real
+func Plan[T any](ctx context.Context, deps KubeContext, kind string, res Resource[T]) (ResourcePlan, error) {
+	plan := ResourcePlan{
+		Kind: kind,
+		Name: res.Name(),
+	}
+
+	found, err := res.Get(ctx, deps)
+	if errors.IsNotFound(err) {
+		plan.Action = PlanCreate
+		return plan, nil
+	}
+
+	if err != nil {
+		return plan, fmt.Errorf("getting resource: %w", err)
+	}
+
+	if failure(res, found) != nil {
+		plan.Action = PlanRecreate
+		return plan, nil
+	}
+
+	applied, err := res.Apply(ctx, deps, true)
+	if errors.IsInvalid(err) {
+		plan.Action = PlanRecreate
+		return plan, nil
+	}
+
+	if err != nil {
+		return plan, fmt.Errorf("applying resource: %w", err)
+	}
+
+	// a dry run doesn't bump the version, so the resources are compared instead
+	updated, err := changed(found, applied)
+	if err != nil {
+		return plan, err
+	}
+
+	if updated {
+		plan.Action = PlanUpdate
+	} else {
+		plan.Action = PlanUnchanged
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,193 @@
+package kube
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/BSFishy/mora-manager/core"
+	"github.com/BSFishy/mora-manager/def"
+	"github.com/BSFishy/mora-manager/util"
+	"github.com/BSFishy/mora-manager/value"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+const (
+	// the volume that files are mounted from
+	filesVolume = "mora-files"
+	// pod annotation holding def.Pod.Checksum
+	checksumAnnotation = "mora.checksum"
+)
+
+// sidecars rely on init containers with a restart policy, which kubernetes
+// only supports from 1.29 on
+var sidecarVersion = version.MajorMinor(1, 29)
+
+// MainContainerName is the name of the container that runs the service itself,
+// next to its init containers and sidecars
+func MainContainerName(moduleName, serviceName string) string {
+	return util.SanitizeDNS1123Label(util.SanitizeDNS1123Subdomain(fmt.Sprintf("%s-%s", moduleName, serviceName)))
+}
+
+// checkSidecars makes sure the cluster can run the pod's sidecars. older
+// clusters would silently run them as init containers and never start the
+// service
+func checkSidecars(deps core.HasClientSet, pod def.Pod) error {
+	if len(pod.Sidecars) == 0 {
+		return nil
+	}
+
+	info, err := deps.GetClientset().Discovery().ServerVersion()
+	if err != nil {
+		return fmt.Errorf("getting server version: %w", err)
+	}
+
+	serverVersion, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return fmt.Errorf("parsing server version %s: %w", info.GitVersion, err)
+	}
+
+	if !serverVersion.AtLeast(sidecarVersion) {
+		return fmt.Errorf("sidecars need kubernetes %s or newer, the cluster is running %s", sidecarVersion, info.GitVersion)
+	}
+
+	return nil
+}
+
+func podAnnotations(pod def.Pod) map[string]string {
+	if pod.Checksum == "" {
+		return nil
+	}
+
+	return map[string]string{
+		checksumAnnotation: pod.Checksum,
+	}
+}
+
+// podSpec builds the pod that runs a service. the same pod is used by every
+// kind of workload
+func podSpec(name string, pod def.Pod, serviceAccount string) *corev1apply.PodSpecApplyConfiguration {
+	container := corev1apply.Container().
+		WithName(util.SanitizeDNS1123Label(name)).
+		WithImage(pod.Image).
+		WithCommand(pod.Command...).
+		WithEnv(envVars(pod.Env)...).
+		WithResources(resourceRequirements(pod.Scheduling.Resources))
+
+	for _, p := range pod.Ports {
+		port := corev1apply.ContainerPort().
+			WithContainerPort(p.TargetPort).
+			WithProtocol(corev1.Protocol(p.Protocol))
+		if p.Name != "" {
+			port.WithName(p.Name)
+		}
+
+		container.WithPorts(port)
+	}
+
+	for _, v := range pod.Volumes {
+		container.WithVolumeMounts(corev1apply.VolumeMount().
+			WithName(v.Name).
+			WithMountPath(v.Path))
+	}
+
+	if p := probe(pod.Probes.Liveness); p != nil {
+		container.WithLivenessProbe(p)
+	}
+
+	if p := probe(pod.Probes.Readiness); p != nil {
+		container.WithReadinessProbe(p)
+	}
+
+	if p := probe(pod.Probes.Startup); p != nil {
+		container.WithStartupProbe(p)
+	}
+
+	spec := corev1apply.PodSpec().
+		WithInitContainers(sidecars(pod.Sidecars)...).
+		WithInitContainers(initContainers(pod.InitContainers)...).
+		WithNodeSelector(pod.Scheduling.NodeSelector).
+		WithTolerations(tolerations(pod.Scheduling.Tolerations)...)
+
+	if serviceAccount != "" {
+		spec.WithServiceAccountName(serviceAccount)
+	}
+
+	// every file comes from the same volume, under its index. the files are
+	// mounted one by one so they can go anywhere without hiding what's already
+	// in the directory
+	if len(pod.Files) > 0 {
+		projected := corev1apply.ProjectedVolumeSource()
+		for i, f := range pod.Files {
+			if f.Secret != "" {
+				projected.WithSources(corev1apply.VolumeProjection().
+					WithSecret(corev1apply.SecretProjection().
+						WithName(f.Secret).
+						WithItems(corev1apply.KeyToPath().WithKey(secretKey).WithPath(strconv.Itoa(i)))))
+			} else {
+				projected.WithSources(corev1apply.VolumeProjection().
+					WithConfigMap(corev1apply.ConfigMapProjection().
+						WithName(f.ConfigMap).
+						WithItems(corev1apply.KeyToPath().WithKey(f.Key).WithPath(strconv.Itoa(i)))))
+			}
+
+			container.WithVolumeMounts(corev1apply.VolumeMount().
+				WithName(filesVolume).
+				WithMountPath(f.Path).
+				WithSubPath(strconv.Itoa(i)).
+				WithReadOnly(true))
+		}
+
+		spec.WithVolumes(corev1apply.Volume().
+			WithName(filesVolume).
+			WithProjected(projected))
+	}
+
+	return spec.WithContainers(container)
+}
+
+func envVars(env []def.Env) []*corev1apply.EnvVarApplyConfiguration {
+	result := make([]*corev1apply.EnvVarApplyConfiguration, len(env))
+	for i, e := range env {
+		result[i] = corev1apply.EnvVar().WithName(e.Name)
+		if e.Value.Kind() == value.Secret {
+			result[i].WithValueFrom(corev1apply.EnvVarSource().
+				WithSecretKeyRef(corev1apply.SecretKeySelector().
+					WithName(e.Value.String()).
+					WithKey(secretKey)))
+		} else {
+			result[i].WithValue(e.Value.String())
+		}
+	}
+
+	return result
+}
+
+func extraContainer(c def.Container) *corev1apply.ContainerApplyConfiguration {
+	return corev1apply.Container().
+		WithName(c.Name).
+		WithImage(c.Image).
+		WithCommand(c.Command...).
+		WithEnv(envVars(c.Env)...)
+}
+
+func initContainers(containers []def.Container) []*corev1apply.ContainerApplyConfiguration {
+	result := make([]*corev1apply.ContainerApplyConfiguration, len(containers))
+	for i, c := range containers {
+		result[i] = extraContainer(c)
+	}
+
+	return result
+}
+
+// sidecars are init containers that are restarted whenever they exit. they
+// keep running next to the service and don't hold up jobs from completing
+func sidecars(containers []def.Container) []*corev1apply.ContainerApplyConfiguration {
+	result := make([]*corev1apply.ContainerApplyConfiguration, len(containers))
+	for i, c := range containers {
+		result[i] = extraContainer(c).WithRestartPolicy(corev1.ContainerRestartPolicyAlways)
+	}
+
+	return result
+}
@@ -0,0 +1,51 @@
+package kube
+
+import (
+	"github.com/BSFishy/mora-manager/def"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+// probe builds a probe, leaving out the settings that aren't set so kubernetes
+// fills in its defaults
+func probe(p *def.Probe) *corev1apply.ProbeApplyConfiguration {
+	if p == nil {
+		return nil
+	}
+
+	probe := corev1apply.Probe()
+	if p.InitialDelaySeconds != 0 {
+		probe.WithInitialDelaySeconds(p.InitialDelaySeconds)
+	}
+
+	if p.PeriodSeconds != 0 {
+		probe.WithPeriodSeconds(p.PeriodSeconds)
+	}
+
+	if p.TimeoutSeconds != 0 {
+		probe.WithTimeoutSeconds(p.TimeoutSeconds)
+	}
+
+	if p.SuccessThreshold != 0 {
+		probe.WithSuccessThreshold(p.SuccessThreshold)
+	}
+
+	if p.FailureThreshold != 0 {
+		probe.WithFailureThreshold(p.FailureThreshold)
+	}
+
+	switch {
+	case p.Http != nil:
+		probe.WithHTTPGet(corev1apply.HTTPGetAction().
+			WithPath(p.Http.Path).
+			WithPort(intstr.FromInt32(p.Http.Port)))
+	case p.Tcp != nil:
+		probe.WithTCPSocket(corev1apply.TCPSocketAction().
+			WithPort(intstr.FromInt32(p.Tcp.Port)))
+	default:
+		probe.WithExec(corev1apply.ExecAction().
+			WithCommand(p.Exec...))
+	}
+
+	return probe
+}
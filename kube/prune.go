@@ -0,0 +1,263 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/BSFishy/mora-manager/core"
+	"github.com/BSFishy/mora-manager/def"
+	"github.com/BSFishy/mora-manager/util"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+type deleteFunc func(context.Context, string, metav1.DeleteOptions) error
+
+// Prune deletes every resource that mora manages in the environment's namespace
+// that isn't in keep. it returns the resources that were deleted. a dry run goes
+// through the same checks as a real delete, but doesn't delete anything
+func Prune(ctx context.Context, deps interface {
+	core.HasClientSet
+	core.HasUser
+	core.HasEnvironment
+}, keep []def.ResourceRef, dryRun bool,
+) ([]def.ResourceRef, error) {
+	clientset := deps.GetClientset()
+	ns := namespace(deps)
+
+	keepSet := make(map[def.ResourceRef]bool, len(keep))
+	for _, ref := range keep {
+		keepSet[ref] = true
+	}
+
+	// only look at resources that were created by us, for this environment
+	opts := metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{
+			"mora.enabled":     "true",
+			"mora.user":        deps.GetUser(),
+			"mora.environment": deps.GetEnvironment(),
+		}).String(),
+	}
+
+	pruned := []def.ResourceRef{}
+
+	// dependents before the things they depend on, the reverse of how they are
+	// deployed
+	ingresses, err := clientset.NetworkingV1().Ingresses(ns).List(ctx, opts)
+	if err != nil {
+		return pruned, fmt.Errorf("listing ingresses: %w", err)
+	}
+
+	names := make([]string, len(ingresses.Items))
+	for i, item := range ingresses.Items {
+		names[i] = item.Name
+	}
+
+	if pruned, err = pruneNames(ctx, pruned, keepSet, def.KindIngress, names, dryRun, clientset.NetworkingV1().Ingresses(ns).Delete); err != nil {
+		return pruned, err
+	}
+
+	services, err := clientset.CoreV1().Services(ns).List(ctx, opts)
+	if err != nil {
+		return pruned, fmt.Errorf("listing services: %w", err)
+	}
+
+	names = make([]string, len(services.Items))
+	for i, item := range services.Items {
+		names[i] = item.Name
+	}
+
+	if pruned, err = pruneNames(ctx, pruned, keepSet, def.KindService, names, dryRun, clientset.CoreV1().Services(ns).Delete); err != nil {
+		return pruned, err
+	}
+
+	deployments, err := clientset.AppsV1().Deployments(ns).List(ctx, opts)
+	if err != nil {
+		return pruned, fmt.Errorf("listing deployments: %w", err)
+	}
+
+	names = make([]string, len(deployments.Items))
+	for i, item := range deployments.Items {
+		names[i] = item.Name
+	}
+
+	if pruned, err = pruneNames(ctx, pruned, keepSet, def.KindDeployment, names, dryRun, clientset.AppsV1().Deployments(ns).Delete); err != nil {
+		return pruned, err
+	}
+
+	cronJobs, err := clientset.BatchV1().CronJobs(ns).List(ctx, opts)
+	if err != nil {
+		return pruned, fmt.Errorf("listing cron jobs: %w", err)
+	}
+
+	names = make([]string, len(cronJobs.Items))
+	for i, item := range cronJobs.Items {
+		names[i] = item.Name
+	}
+
+	if pruned, err = pruneNames(ctx, pruned, keepSet, def.KindCronJob, names, dryRun, withPropagation(clientset.BatchV1().CronJobs(ns).Delete, deleteJobPods)); err != nil {
+		return pruned, err
+	}
+
+	// jobs created by cron jobs copy their labels, but they belong to the cron
+	// job and go away with it
+	jobs, err := clientset.BatchV1().Jobs(ns).List(ctx, opts)
+	if err != nil {
+		return pruned, fmt.Errorf("listing jobs: %w", err)
+	}
+
+	names = []string{}
+	for _, item := range jobs.Items {
+		if metav1.GetControllerOf(&item) == nil {
+			names = append(names, item.Name)
+		}
+	}
+
+	if pruned, err = pruneNames(ctx, pruned, keepSet, def.KindJob, names, dryRun, withPropagation(clientset.BatchV1().Jobs(ns).Delete, deleteJobPods)); err != nil {
+		return pruned, err
+	}
+
+	// the claims of pruned stateful sets are left alone. deleting data is up to
+	// the user
+	statefulSets, err := clientset.AppsV1().StatefulSets(ns).List(ctx, opts)
+	if err != nil {
+		return pruned, fmt.Errorf("listing stateful sets: %w", err)
+	}
+
+	names = make([]string, len(statefulSets.Items))
+	for i, item := range statefulSets.Items {
+		names[i] = item.Name
+	}
+
+	if pruned, err = pruneNames(ctx, pruned, keepSet, def.KindStatefulSet, names, dryRun, clientset.AppsV1().StatefulSets(ns).Delete); err != nil {
+		return pruned, err
+	}
+
+	policies, err := clientset.NetworkingV1().NetworkPolicies(ns).List(ctx, opts)
+	if err != nil {
+		return pruned, fmt.Errorf("listing network policies: %w", err)
+	}
+
+	names = make([]string, len(policies.Items))
+	for i, item := range policies.Items {
+		names[i] = item.Name
+	}
+
+	if pruned, err = pruneNames(ctx, pruned, keepSet, def.KindNetworkPolicy, names, dryRun, clientset.NetworkingV1().NetworkPolicies(ns).Delete); err != nil {
+		return pruned, err
+	}
+
+	configMaps, err := clientset.CoreV1().ConfigMaps(ns).List(ctx, opts)
+	if err != nil {
+		return pruned, fmt.Errorf("listing config maps: %w", err)
+	}
+
+	names = make([]string, len(configMaps.Items))
+	for i, item := range configMaps.Items {
+		names[i] = item.Name
+	}
+
+	if pruned, err = pruneNames(ctx, pruned, keepSet, def.KindConfigMap, names, dryRun, clientset.CoreV1().ConfigMaps(ns).Delete); err != nil {
+		return pruned, err
+	}
+
+	secrets, err := clientset.CoreV1().Secrets(ns).List(ctx, opts)
+	if err != nil {
+		return pruned, fmt.Errorf("listing secrets: %w", err)
+	}
+
+	names = make([]string, len(secrets.Items))
+	for i, item := range secrets.Items {
+		names[i] = item.Name
+	}
+
+	if pruned, err = pruneNames(ctx, pruned, keepSet, def.KindSecret, names, dryRun, clientset.CoreV1().Secrets(ns).Delete); err != nil {
+		return pruned, err
+	}
+
+	accounts, err := clientset.CoreV1().ServiceAccounts(ns).List(ctx, opts)
+	if err != nil {
+		return pruned, fmt.Errorf("listing service accounts: %w", err)
+	}
+
+	names = make([]string, len(accounts.Items))
+	for i, item := range accounts.Items {
+		names[i] = item.Name
+	}
+
+	if pruned, err = pruneNames(ctx, pruned, keepSet, def.KindServiceAccount, names, dryRun, clientset.CoreV1().ServiceAccounts(ns).Delete); err != nil {
+		return pruned, err
+	}
+
+	bindings, err := clientset.RbacV1().RoleBindings(ns).List(ctx, opts)
+	if err != nil {
+		return pruned, fmt.Errorf("listing role bindings: %w", err)
+	}
+
+	names = make([]string, len(bindings.Items))
+	for i, item := range bindings.Items {
+		names[i] = item.Name
+	}
+
+	if pruned, err = pruneNames(ctx, pruned, keepSet, def.KindRoleBinding, names, dryRun, clientset.RbacV1().RoleBindings(ns).Delete); err != nil {
+		return pruned, err
+	}
+
+	roles, err := clientset.RbacV1().Roles(ns).List(ctx, opts)
+	if err != nil {
+		return pruned, fmt.Errorf("listing roles: %w", err)
+	}
+
+	names = make([]string, len(roles.Items))
+	for i, item := range roles.Items {
+		names[i] = item.Name
+	}
+
+	if pruned, err = pruneNames(ctx, pruned, keepSet, def.KindRole, names, dryRun, clientset.RbacV1().Roles(ns).Delete); err != nil {
+		return pruned, err
+	}
+
+	return pruned, nil
+}
+
+// withPropagation makes del delete dependents the given way
+func withPropagation(del deleteFunc, propagation metav1.DeletionPropagation) deleteFunc {
+	return func(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+		opts.PropagationPolicy = &propagation
+		return del(ctx, name, opts)
+	}
+}
+
+func pruneNames(ctx context.Context, pruned []def.ResourceRef, keep map[def.ResourceRef]bool, kind string, names []string, dryRun bool, del deleteFunc) ([]def.ResourceRef, error) {
+	logger := util.LogFromCtx(ctx)
+
+	for _, name := range names {
+		ref := def.ResourceRef{
+			Kind: kind,
+			Name: name,
+		}
+
+		if keep[ref] {
+			continue
+		}
+
+		opts := metav1.DeleteOptions{}
+		if dryRun {
+			opts.DryRun = []string{metav1.DryRunAll}
+		}
+
+		err := del(ctx, name, opts)
+		if err != nil && !errors.IsNotFound(err) {
+			return pruned, fmt.Errorf("deleting %s %s: %w", kind, name, err)
+		}
+
+		if !dryRun {
+			logger.Info("pruned resource", "kind", kind, "name", name)
+		}
+
+		pruned = append(pruned, ref)
+	}
+
+	return pruned, nil
+}
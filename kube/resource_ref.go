@@ -0,0 +1,14 @@
+package kube
+
+import "github.com/BSFishy/mora-manager/def"
+
+func refs[T any](result []def.ResourceRef, kind string, resources []Resource[T]) []def.ResourceRef {
+	for _, res := range resources {
+		result = append(result, def.ResourceRef{
+			Kind: kind,
+			Name: res.Name(),
+		})
+	}
+
+	return result
+}
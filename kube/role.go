@@ -2,10 +2,12 @@ package kube
 
 import (
 	"context"
-	"slices"
 
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	rbacv1apply "k8s.io/client-go/applyconfigurations/rbac/v1"
 )
 
 type Role struct {
@@ -28,66 +30,39 @@ func (r *Role) Get(ctx context.Context, deps KubeContext) (*rbacv1.Role, error)
 	return deps.GetClientset().RbacV1().Roles(namespace(deps)).Get(ctx, r.Name(), metav1.GetOptions{})
 }
 
-func (r *Role) IsValid(ctx context.Context, role *rbacv1.Role) (bool, error) {
-	if len(role.Rules) != len(r.rules) {
-		return false, nil
-	}
-
-	for _, rule := range role.Rules {
-		slices.Sort(rule.APIGroups)
-		slices.Sort(rule.Resources)
-		slices.Sort(rule.Verbs)
-	}
-
-	for _, rule := range r.rules {
-		slices.Sort(rule.APIGroups)
-		slices.Sort(rule.Resources)
-		slices.Sort(rule.Verbs)
-
-		found := false
-		for _, roleRule := range role.Rules {
-			if !slices.Equal(rule.APIGroups, roleRule.APIGroups) {
-				continue
-			}
-
-			if !slices.Equal(rule.Resources, roleRule.Resources) {
-				continue
-			}
-
-			if !slices.Equal(rule.Verbs, roleRule.Verbs) {
-				continue
-			}
-
-			found = true
-			break
-		}
-
-		if !found {
-			return false, nil
-		}
-	}
-
-	return true, nil
+func (r *Role) Patch(ctx context.Context, deps KubeContext, patchType types.PatchType, data []byte) (*rbacv1.Role, error) {
+	return deps.GetClientset().RbacV1().Roles(namespace(deps)).Patch(ctx, r.Name(), patchType, data, metav1.PatchOptions{})
 }
 
 func (r *Role) Delete(ctx context.Context, deps KubeContext) error {
 	return deps.GetClientset().RbacV1().Roles(namespace(deps)).Delete(ctx, r.Name(), metav1.DeleteOptions{})
 }
 
-func (r *Role) Create(ctx context.Context, deps KubeContext) (*rbacv1.Role, error) {
+func (r *Role) build(deps KubeContext) *rbacv1apply.RoleApplyConfiguration {
 	labels := matchLabels(deps, map[string]string{
 		"mora.name": r.name,
 	})
-	role := &rbacv1.Role{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      r.Name(),
-			Namespace: namespace(deps),
-			Labels:    labels,
-		},
-		Rules: r.rules,
+
+	role := rbacv1apply.Role(r.Name(), namespace(deps)).
+		WithLabels(labels)
+
+	for _, rule := range r.rules {
+		role.WithRules(rbacv1apply.PolicyRule().
+			WithAPIGroups(rule.APIGroups...).
+			WithResources(rule.Resources...).
+			WithResourceNames(rule.ResourceNames...).
+			WithVerbs(rule.Verbs...))
 	}
 
-	return deps.GetClientset().RbacV1().Roles(namespace(deps)).Create(ctx, role, metav1.CreateOptions{})
+	return role
+}
+
+func (r *Role) Apply(ctx context.Context, deps KubeContext, dryRun bool) (*rbacv1.Role, error) {
+	return apply(ctx, deps.GetClientset().RbacV1().Roles(namespace(deps)), r.build(deps), dryRun)
+}
+
+func (r *Role) Watch(ctx context.Context, deps KubeContext, opts metav1.ListOptions) (watch.Interface, error) {
+	return deps.GetClientset().RbacV1().Roles(namespace(deps)).Watch(ctx, opts)
 }
 
 func (r *Role) Ready(role *rbacv1.Role) bool {
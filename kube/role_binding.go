@@ -5,6 +5,9 @@ import (
 
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	rbacv1apply "k8s.io/client-go/applyconfigurations/rbac/v1"
 )
 
 type RoleBinding struct {
@@ -29,54 +32,37 @@ func (r *RoleBinding) Get(ctx context.Context, deps KubeContext) (*rbacv1.RoleBi
 	return deps.GetClientset().RbacV1().RoleBindings(namespace(deps)).Get(ctx, r.Name(), metav1.GetOptions{})
 }
 
-func (r *RoleBinding) IsValid(ctx context.Context, binding *rbacv1.RoleBinding) (bool, error) {
-	subjects := binding.Subjects
-	if len(subjects) != 1 {
-		return false, nil
-	}
-
-	subject := subjects[0]
-	if subject.Kind != "ServiceAccount" || subject.Name != r.serviceAccount {
-		return false, nil
-	}
-
-	role := binding.RoleRef
-	if role.Kind != "Role" || role.Name != r.role {
-		return false, nil
-	}
-
-	return true, nil
+func (r *RoleBinding) Patch(ctx context.Context, deps KubeContext, patchType types.PatchType, data []byte) (*rbacv1.RoleBinding, error) {
+	return deps.GetClientset().RbacV1().RoleBindings(namespace(deps)).Patch(ctx, r.Name(), patchType, data, metav1.PatchOptions{})
 }
 
 func (r *RoleBinding) Delete(ctx context.Context, deps KubeContext) error {
 	return deps.GetClientset().RbacV1().RoleBindings(namespace(deps)).Delete(ctx, r.Name(), metav1.DeleteOptions{})
 }
 
-func (r *RoleBinding) Create(ctx context.Context, deps KubeContext) (*rbacv1.RoleBinding, error) {
+func (r *RoleBinding) build(deps KubeContext) *rbacv1apply.RoleBindingApplyConfiguration {
 	labels := matchLabels(deps, map[string]string{
 		"mora.name": r.name,
 	})
-	binding := &rbacv1.RoleBinding{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      r.Name(),
-			Namespace: namespace(deps),
-			Labels:    labels,
-		},
-		Subjects: []rbacv1.Subject{
-			{
-				Kind:      "ServiceAccount",
-				Name:      r.serviceAccount,
-				Namespace: namespace(deps),
-			},
-		},
-		RoleRef: rbacv1.RoleRef{
-			Kind:     "Role",
-			Name:     r.role,
-			APIGroup: "rbac.authorization.k8s.io",
-		},
-	}
 
-	return deps.GetClientset().RbacV1().RoleBindings(namespace(deps)).Create(ctx, binding, metav1.CreateOptions{})
+	return rbacv1apply.RoleBinding(r.Name(), namespace(deps)).
+		WithLabels(labels).
+		WithSubjects(rbacv1apply.Subject().
+			WithKind("ServiceAccount").
+			WithName(r.serviceAccount).
+			WithNamespace(namespace(deps))).
+		WithRoleRef(rbacv1apply.RoleRef().
+			WithKind("Role").
+			WithName(r.role).
+			WithAPIGroup("rbac.authorization.k8s.io"))
+}
+
+func (r *RoleBinding) Apply(ctx context.Context, deps KubeContext, dryRun bool) (*rbacv1.RoleBinding, error) {
+	return apply(ctx, deps.GetClientset().RbacV1().RoleBindings(namespace(deps)), r.build(deps), dryRun)
+}
+
+func (r *RoleBinding) Watch(ctx context.Context, deps KubeContext, opts metav1.ListOptions) (watch.Interface, error) {
+	return deps.GetClientset().RbacV1().RoleBindings(namespace(deps)).Watch(ctx, opts)
 }
 
 func (r *RoleBinding) Ready(binding *rbacv1.RoleBinding) bool {
@@ -0,0 +1,62 @@
+package kube
+
+import (
+	"github.com/BSFishy/mora-manager/def"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+func resourceList(list def.ResourceList) corev1.ResourceList {
+	resources := corev1.ResourceList{}
+
+	// the quantities were validated when the config was evaluated
+	if list.Cpu != "" {
+		resources[corev1.ResourceCPU] = resource.MustParse(list.Cpu)
+	}
+
+	if list.Memory != "" {
+		resources[corev1.ResourceMemory] = resource.MustParse(list.Memory)
+	}
+
+	if len(resources) == 0 {
+		return nil
+	}
+
+	return resources
+}
+
+func resourceRequirements(resources def.Resources) *corev1apply.ResourceRequirementsApplyConfiguration {
+	requirements := corev1apply.ResourceRequirements()
+	if requests := resourceList(resources.Requests); requests != nil {
+		requirements.WithRequests(requests)
+	}
+
+	if limits := resourceList(resources.Limits); limits != nil {
+		requirements.WithLimits(limits)
+	}
+
+	return requirements
+}
+
+func tolerations(tolerations []def.Toleration) []*corev1apply.TolerationApplyConfiguration {
+	result := make([]*corev1apply.TolerationApplyConfiguration, len(tolerations))
+	for i, t := range tolerations {
+		result[i] = corev1apply.Toleration().
+			WithOperator(corev1.TolerationOperator(t.Operator))
+
+		if t.Key != "" {
+			result[i].WithKey(t.Key)
+		}
+
+		if t.Value != "" {
+			result[i].WithValue(t.Value)
+		}
+
+		if t.Effect != "" {
+			result[i].WithEffect(corev1.TaintEffect(t.Effect))
+		}
+	}
+
+	return result
+}
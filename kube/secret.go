@@ -3,12 +3,14 @@ package kube
 import (
 	"context"
 	"fmt"
-	"slices"
 
 	"github.com/BSFishy/mora-manager/core"
 	"github.com/BSFishy/mora-manager/util"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
 )
 
 type Secret struct {
@@ -40,42 +42,34 @@ func (s *Secret) Get(ctx context.Context, deps KubeContext) (*corev1.Secret, err
 	return deps.GetClientset().CoreV1().Secrets(namespace(deps)).Get(ctx, s.Name(), metav1.GetOptions{})
 }
 
-func (s *Secret) IsValid(ctx context.Context, secret *corev1.Secret) (bool, error) {
-	data, ok := secret.Data[secretKey]
-	if !ok {
-		return false, nil
-	}
-
-	if slices.Compare(data, s.value) != 0 {
-		return false, nil
-	}
-
-	return true, nil
+func (s *Secret) Patch(ctx context.Context, deps KubeContext, patchType types.PatchType, data []byte) (*corev1.Secret, error) {
+	return deps.GetClientset().CoreV1().Secrets(namespace(deps)).Patch(ctx, s.Name(), patchType, data, metav1.PatchOptions{})
 }
 
 func (s *Secret) Delete(ctx context.Context, deps KubeContext) error {
 	return deps.GetClientset().CoreV1().Secrets(namespace(deps)).Delete(ctx, s.Name(), metav1.DeleteOptions{})
 }
 
-func (s *Secret) Create(ctx context.Context, deps KubeContext) (*corev1.Secret, error) {
+func (s *Secret) build(deps KubeContext) *corev1apply.SecretApplyConfiguration {
 	labels := matchLabels(deps, map[string]string{
 		"mora.identifier": s.identifier,
 	})
 
-	secret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Namespace: namespace(deps),
-			Name:      s.Name(),
-			Labels:    labels,
-		},
-		Data: map[string][]byte{
+	return corev1apply.Secret(s.Name(), namespace(deps)).
+		WithLabels(labels).
+		WithData(map[string][]byte{
 			secretKey: s.value,
-		},
+		}).
 		// TODO: make this configurable?
-		Type: corev1.SecretTypeOpaque,
-	}
+		WithType(corev1.SecretTypeOpaque)
+}
+
+func (s *Secret) Apply(ctx context.Context, deps KubeContext, dryRun bool) (*corev1.Secret, error) {
+	return apply(ctx, deps.GetClientset().CoreV1().Secrets(namespace(deps)), s.build(deps), dryRun)
+}
 
-	return deps.GetClientset().CoreV1().Secrets(namespace(deps)).Create(ctx, secret, metav1.CreateOptions{})
+func (s *Secret) Watch(ctx context.Context, deps KubeContext, opts metav1.ListOptions) (watch.Interface, error) {
+	return deps.GetClientset().CoreV1().Secrets(namespace(deps)).Watch(ctx, opts)
 }
 
 func (s *Secret) Ready(secret *corev1.Secret) bool {
@@ -85,7 +79,12 @@ func (s *Secret) Ready(secret *corev1.Secret) bool {
 
 // GetSecret reads a secret using the identifier. this should be the full name
 // of the resource, including the module name
-func GetSecret(ctx context.Context, deps KubeContext, identifier string) ([]byte, error) {
+func GetSecret(ctx context.Context, deps interface {
+	core.HasClientSet
+	core.HasUser
+	core.HasEnvironment
+}, identifier string,
+) ([]byte, error) {
 	res, err := deps.GetClientset().CoreV1().Secrets(namespace(deps)).Get(ctx, identifier, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
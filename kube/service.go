@@ -3,32 +3,69 @@ package kube
 import (
 	"context"
 	"fmt"
+	"strconv"
 
 	"github.com/BSFishy/mora-manager/core"
+	"github.com/BSFishy/mora-manager/def"
 	"github.com/BSFishy/mora-manager/util"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
 )
 
+// the port that wingmen serve their http api on
+const wingmanPort = 8080
+
 type Service struct {
 	moduleName  string
 	serviceName string
 	isWingman   bool
+	ports       []def.Port
+	// headless services don't get a cluster ip. dns points straight at the
+	// pods instead, which is what stateful sets need to give each pod its own
+	// address
+	headless bool
 }
 
 func NewService(deps interface {
 	core.HasModuleName
 	core.HasServiceName
-}, isWingman bool,
+}, isWingman bool, ports []def.Port,
 ) Resource[corev1.Service] {
 	moduleName := deps.GetModuleName()
 	serviceName := deps.GetServiceName()
 
+	if isWingman {
+		ports = []def.Port{
+			{
+				Port:       wingmanPort,
+				TargetPort: wingmanPort,
+				Protocol:   string(corev1.ProtocolTCP),
+			},
+		}
+	}
+
 	return &Service{
 		moduleName:  moduleName,
 		serviceName: serviceName,
 		isWingman:   isWingman,
+		ports:       ports,
+	}
+}
+
+func NewHeadlessService(deps interface {
+	core.HasModuleName
+	core.HasServiceName
+}, ports []def.Port,
+) Resource[corev1.Service] {
+	return &Service{
+		moduleName:  deps.GetModuleName(),
+		serviceName: deps.GetServiceName(),
+		ports:       ports,
+		headless:    true,
 	}
 }
 
@@ -45,56 +82,52 @@ func (s *Service) Get(ctx context.Context, deps KubeContext) (*corev1.Service, e
 	return deps.GetClientset().CoreV1().Services(namespace(deps)).Get(ctx, s.Name(), metav1.GetOptions{})
 }
 
-// TODO: support non-wingman
-func (s *Service) IsValid(ctx context.Context, service *corev1.Service) (bool, error) {
-	if !s.isWingman {
-		return true, nil
-	}
-
-	ports := service.Spec.Ports
-	if len(ports) != 1 {
-		return false, nil
-	}
-
-	port := ports[0]
-	if port.Port != 8080 || port.TargetPort.IntValue() != 8080 {
-		return false, nil
-	}
-
-	return true, nil
+func (s *Service) Patch(ctx context.Context, deps KubeContext, patchType types.PatchType, data []byte) (*corev1.Service, error) {
+	return deps.GetClientset().CoreV1().Services(namespace(deps)).Patch(ctx, s.Name(), patchType, data, metav1.PatchOptions{})
 }
 
 func (s *Service) Delete(ctx context.Context, deps KubeContext) error {
 	return deps.GetClientset().CoreV1().Services(namespace(deps)).Delete(ctx, s.Name(), metav1.DeleteOptions{})
 }
 
-// TODO: support non-wingman
-func (s *Service) Create(ctx context.Context, deps KubeContext) (*corev1.Service, error) {
-	if !s.isWingman {
-		return nil, nil
-	}
-
+func (s *Service) build(deps KubeContext) *corev1apply.ServiceApplyConfiguration {
 	labels := matchLabels(deps, map[string]string{
-		"mora.wingman": "true",
+		"mora.wingman": strconv.FormatBool(s.isWingman),
 	})
-	service := &corev1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Namespace: namespace(deps),
-			Name:      s.Name(),
-			Labels:    labels,
-		},
-		Spec: corev1.ServiceSpec{
-			Selector: labels,
-			Ports: []corev1.ServicePort{
-				{
-					Port:       8080,
-					TargetPort: intstr.FromInt32(8080),
-				},
-			},
-		},
+
+	spec := corev1apply.ServiceSpec().
+		WithType(corev1.ServiceTypeClusterIP).
+		WithSelector(labels)
+
+	for _, p := range s.ports {
+		port := corev1apply.ServicePort().
+			WithPort(p.Port).
+			WithTargetPort(intstr.FromInt32(p.TargetPort)).
+			WithProtocol(corev1.Protocol(p.Protocol))
+		if p.Name != "" {
+			port.WithName(p.Name)
+		}
+
+		spec.WithPorts(port)
 	}
 
-	return deps.GetClientset().CoreV1().Services(namespace(deps)).Create(ctx, service, metav1.CreateOptions{})
+	// the cluster ip is assigned once and can't be changed, so switching
+	// between headless and not gets the service recreated
+	if s.headless {
+		spec.WithClusterIP(corev1.ClusterIPNone)
+	}
+
+	return corev1apply.Service(s.Name(), namespace(deps)).
+		WithLabels(labels).
+		WithSpec(spec)
+}
+
+func (s *Service) Apply(ctx context.Context, deps KubeContext, dryRun bool) (*corev1.Service, error) {
+	return apply(ctx, deps.GetClientset().CoreV1().Services(namespace(deps)), s.build(deps), dryRun)
+}
+
+func (s *Service) Watch(ctx context.Context, deps KubeContext, opts metav1.ListOptions) (watch.Interface, error) {
+	return deps.GetClientset().CoreV1().Services(namespace(deps)).Watch(ctx, opts)
 }
 
 func (s *Service) Ready(service *corev1.Service) bool {
@@ -109,7 +142,11 @@ func (s *Service) Ready(service *corev1.Service) bool {
 
 		return true
 	case corev1.ServiceTypeClusterIP:
-		return service.Spec.ClusterIP != "" && service.Spec.ClusterIP != "None"
+		if s.headless {
+			return true
+		}
+
+		return service.Spec.ClusterIP != "" && service.Spec.ClusterIP != corev1.ClusterIPNone
 	case corev1.ServiceTypeNodePort:
 		for _, port := range service.Spec.Ports {
 			if port.NodePort == 0 {
@@ -5,6 +5,9 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
 )
 
 type ServiceAccount struct {
@@ -25,27 +28,29 @@ func (s *ServiceAccount) Get(ctx context.Context, deps KubeContext) (*corev1.Ser
 	return deps.GetClientset().CoreV1().ServiceAccounts(namespace(deps)).Get(ctx, s.Name(), metav1.GetOptions{})
 }
 
-func (s *ServiceAccount) IsValid(ctx context.Context, account *corev1.ServiceAccount) (bool, error) {
-	return true, nil
+func (s *ServiceAccount) Patch(ctx context.Context, deps KubeContext, patchType types.PatchType, data []byte) (*corev1.ServiceAccount, error) {
+	return deps.GetClientset().CoreV1().ServiceAccounts(namespace(deps)).Patch(ctx, s.Name(), patchType, data, metav1.PatchOptions{})
 }
 
 func (s *ServiceAccount) Delete(ctx context.Context, deps KubeContext) error {
 	return deps.GetClientset().CoreV1().ServiceAccounts(namespace(deps)).Delete(ctx, s.Name(), metav1.DeleteOptions{})
 }
 
-func (s *ServiceAccount) Create(ctx context.Context, deps KubeContext) (*corev1.ServiceAccount, error) {
+func (s *ServiceAccount) build(deps KubeContext) *corev1apply.ServiceAccountApplyConfiguration {
 	labels := matchLabels(deps, map[string]string{
 		"mora.name": s.name,
 	})
-	account := &corev1.ServiceAccount{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      s.Name(),
-			Namespace: namespace(deps),
-			Labels:    labels,
-		},
-	}
 
-	return deps.GetClientset().CoreV1().ServiceAccounts(namespace(deps)).Create(ctx, account, metav1.CreateOptions{})
+	return corev1apply.ServiceAccount(s.Name(), namespace(deps)).
+		WithLabels(labels)
+}
+
+func (s *ServiceAccount) Apply(ctx context.Context, deps KubeContext, dryRun bool) (*corev1.ServiceAccount, error) {
+	return apply(ctx, deps.GetClientset().CoreV1().ServiceAccounts(namespace(deps)), s.build(deps), dryRun)
+}
+
+func (s *ServiceAccount) Watch(ctx context.Context, deps KubeContext, opts metav1.ListOptions) (watch.Interface, error) {
+	return deps.GetClientset().CoreV1().ServiceAccounts(namespace(deps)).Watch(ctx, opts)
 }
 
 func (s *ServiceAccount) Ready(account *corev1.ServiceAccount) bool {
@@ -0,0 +1,153 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/BSFishy/mora-manager/core"
+	"github.com/BSFishy/mora-manager/def"
+	"github.com/BSFishy/mora-manager/util"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	appsv1apply "k8s.io/client-go/applyconfigurations/apps/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	metav1apply "k8s.io/client-go/applyconfigurations/meta/v1"
+)
+
+type StatefulSet struct {
+	moduleName  string
+	serviceName string
+	pod         def.Pod
+	// the headless service that gives the pods their addresses
+	governingService string
+}
+
+func NewStatefulSet(deps interface {
+	core.HasModuleName
+	core.HasServiceName
+}, pod def.Pod, governingService string,
+) Resource[appsv1.StatefulSet] {
+	return &StatefulSet{
+		moduleName:       deps.GetModuleName(),
+		serviceName:      deps.GetServiceName(),
+		pod:              pod,
+		governingService: governingService,
+	}
+}
+
+func (s *StatefulSet) Name() string {
+	return util.SanitizeDNS1123Subdomain(fmt.Sprintf("%s-%s", s.moduleName, s.serviceName))
+}
+
+func (s *StatefulSet) Get(ctx context.Context, deps KubeContext) (*appsv1.StatefulSet, error) {
+	return deps.GetClientset().AppsV1().StatefulSets(namespace(deps)).Get(ctx, s.Name(), metav1.GetOptions{})
+}
+
+func (s *StatefulSet) Patch(ctx context.Context, deps KubeContext, patchType types.PatchType, data []byte) (*appsv1.StatefulSet, error) {
+	return deps.GetClientset().AppsV1().StatefulSets(namespace(deps)).Patch(ctx, s.Name(), patchType, data, metav1.PatchOptions{})
+}
+
+func (s *StatefulSet) Delete(ctx context.Context, deps KubeContext) error {
+	return deps.GetClientset().AppsV1().StatefulSets(namespace(deps)).Delete(ctx, s.Name(), metav1.DeleteOptions{})
+}
+
+func (s *StatefulSet) build(deps KubeContext) *appsv1apply.StatefulSetApplyConfiguration {
+	labels := matchLabels(deps, map[string]string{
+		"mora.wingman": "false",
+	})
+
+	// claim templates can't be changed, so changing a volume gets the stateful
+	// set recreated. the claims themselves are kept
+	claims := make([]*corev1apply.PersistentVolumeClaimApplyConfiguration, len(s.pod.Volumes))
+	for i, v := range s.pod.Volumes {
+		spec := corev1apply.PersistentVolumeClaimSpec().
+			WithAccessModes(corev1.ReadWriteOnce).
+			WithResources(corev1apply.VolumeResourceRequirements().
+				WithRequests(corev1.ResourceList{
+					// validated when the config was evaluated
+					corev1.ResourceStorage: resource.MustParse(v.Size),
+				}))
+
+		if v.StorageClass != "" {
+			spec.WithStorageClassName(v.StorageClass)
+		}
+
+		// templates don't live in a namespace of their own, so they're built
+		// without one
+		claims[i] = (&corev1apply.PersistentVolumeClaimApplyConfiguration{}).
+			WithName(v.Name).
+			WithLabels(labels).
+			WithSpec(spec)
+	}
+
+	spec := appsv1apply.StatefulSetSpec().
+		WithServiceName(s.governingService).
+		WithSelector(metav1apply.LabelSelector().
+			WithMatchLabels(labels)).
+		WithUpdateStrategy(appsv1apply.StatefulSetUpdateStrategy().
+			WithType(appsv1.RollingUpdateStatefulSetStrategyType)).
+		// this is the default, but losing data by accident would be bad enough
+		// to spell it out. claims stay around when the stateful set is recreated,
+		// scaled down or pruned
+		WithPersistentVolumeClaimRetentionPolicy(appsv1apply.StatefulSetPersistentVolumeClaimRetentionPolicy().
+			WithWhenDeleted(appsv1.RetainPersistentVolumeClaimRetentionPolicyType).
+			WithWhenScaled(appsv1.RetainPersistentVolumeClaimRetentionPolicyType)).
+		WithTemplate(corev1apply.PodTemplateSpec().
+			WithLabels(labels).
+			WithAnnotations(podAnnotations(s.pod)).
+			WithSpec(podSpec(s.Name(), s.pod, ""))).
+		WithVolumeClaimTemplates(claims...)
+
+	// left to whoever scales the stateful set when the service doesn't say
+	if s.pod.Scheduling.Replicas != nil {
+		spec.WithReplicas(*s.pod.Scheduling.Replicas)
+	}
+
+	return appsv1apply.StatefulSet(s.Name(), namespace(deps)).
+		WithLabels(labels).
+		WithSpec(spec)
+}
+
+func (s *StatefulSet) Apply(ctx context.Context, deps KubeContext, dryRun bool) (*appsv1.StatefulSet, error) {
+	if err := checkSidecars(deps, s.pod); err != nil {
+		return nil, err
+	}
+
+	return apply(ctx, deps.GetClientset().AppsV1().StatefulSets(namespace(deps)), s.build(deps), dryRun)
+}
+
+func (s *StatefulSet) Watch(ctx context.Context, deps KubeContext, opts metav1.ListOptions) (watch.Interface, error) {
+	return deps.GetClientset().AppsV1().StatefulSets(namespace(deps)).Watch(ctx, opts)
+}
+
+func (s *StatefulSet) Diagnose(ctx context.Context, deps KubeContext) (*Diagnostics, error) {
+	return diagnosePods(ctx, deps, def.KindStatefulSet, s.Name(), matchLabels(deps, map[string]string{
+		"mora.wingman": "false",
+	}))
+}
+
+// Ready checks whether the latest rollout finished, the same way that kubectl
+// rollout status does
+func (s *StatefulSet) Ready(statefulSet *appsv1.StatefulSet) bool {
+	if statefulSet.Status.ObservedGeneration < statefulSet.Generation {
+		return false
+	}
+
+	replicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+
+	status := statefulSet.Status
+	if status.ReadyReplicas < replicas || status.UpdatedReplicas < replicas {
+		return false
+	}
+
+	// pods are replaced one at a time, so the rollout is done once the current
+	// revision catches up
+	return status.CurrentRevision == status.UpdateRevision
+}
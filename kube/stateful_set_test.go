@@ -0,0 +1,74 @@
+package kube
+
+import (
+	"testing"
+
+	"github.com/BSFishy/mora-manager/def"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestStatefulSetClaimsItsVolumes(t *testing.T) {
+	deps := &testContext{moduleName: "db", serviceName: "postgres"}
+	pod := def.Pod{
+		Image: "postgres:17",
+		Volumes: []def.Volume{
+			{Name: "data", Path: "/var/lib/postgresql", Size: "1Gi"},
+			{Name: "logs", Path: "/var/log", Size: "100Mi", StorageClass: "fast"},
+		},
+	}
+
+	governing := NewHeadlessService(deps, nil)
+	statefulSet := NewStatefulSet(deps, pod, governing.Name()).(*StatefulSet).build(deps)
+	spec := statefulSet.Spec
+
+	if spec.ServiceName == nil || *spec.ServiceName != governing.Name() {
+		t.Errorf("expected the governing service %s, got %v", governing.Name(), spec.ServiceName)
+	}
+
+	if spec.Replicas != nil {
+		t.Errorf("expected replicas to be left unset, got %d", *spec.Replicas)
+	}
+
+	claims := spec.VolumeClaimTemplates
+	if len(claims) != len(pod.Volumes) {
+		t.Fatalf("expected %d claim templates, got %d", len(pod.Volumes), len(claims))
+	}
+
+	container := spec.Template.Spec.Containers[0]
+	for i, v := range pod.Volumes {
+		claim := claims[i]
+		if *claim.Name != v.Name {
+			t.Errorf("expected claim %s, got %s", v.Name, *claim.Name)
+		}
+
+		if claim.Namespace != nil {
+			t.Errorf("expected claim %s to have no namespace, got %s", v.Name, *claim.Namespace)
+		}
+
+		size := (*claim.Spec.Resources.Requests)[corev1.ResourceStorage]
+		if size.Cmp(resource.MustParse(v.Size)) != 0 {
+			t.Errorf("expected claim %s to request %s, got %s", v.Name, v.Size, size.String())
+		}
+
+		if v.StorageClass == "" && claim.Spec.StorageClassName != nil {
+			t.Errorf("expected claim %s to use the default storage class", v.Name)
+		} else if v.StorageClass != "" && (claim.Spec.StorageClassName == nil || *claim.Spec.StorageClassName != v.StorageClass) {
+			t.Errorf("expected claim %s to use storage class %s", v.Name, v.StorageClass)
+		}
+
+		mount := container.VolumeMounts[i]
+		if *mount.Name != v.Name || *mount.MountPath != v.Path {
+			t.Errorf("expected %s to be mounted at %s, got %s at %s", v.Name, v.Path, *mount.Name, *mount.MountPath)
+		}
+	}
+}
+
+func TestHeadlessServiceHasNoClusterIP(t *testing.T) {
+	deps := &testContext{moduleName: "db", serviceName: "postgres"}
+	service := NewHeadlessService(deps, nil).(*Service).build(deps)
+
+	if service.Spec.ClusterIP == nil || *service.Spec.ClusterIP != corev1.ClusterIPNone {
+		t.Errorf("expected a headless service, got cluster ip %v", service.Spec.ClusterIP)
+	}
+}
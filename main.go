@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"embed"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/BSFishy/mora-manager/expr"
 	"github.com/BSFishy/mora-manager/function"
@@ -27,6 +32,18 @@ type App struct {
 	secret    string
 	registry  expr.FunctionRegistry
 	manager   *wingman.Manager
+	notifier  *model.Notifier
+
+	// maximum number of services deployed at the same time within a single
+	// deployment
+	concurrency int
+	// how long a service gets to become ready, unless it sets its own timeout
+	deployTimeout time.Duration
+
+	// identifies this replica in the job queue
+	workerId string
+	workers  int
+	lease    time.Duration
 }
 
 func (a *App) GetClientset() kubernetes.Interface {
@@ -74,15 +91,69 @@ func NewApp() App {
 		slog.Info("setup secret", "secret", secret)
 	}
 
+	concurrency, err := strconv.Atoi(DEPLOY_CONCURRENCY)
+	if err != nil {
+		panic(fmt.Errorf("parsing deploy concurrency: %w", err))
+	}
+
+	if concurrency < 1 {
+		panic("deploy concurrency must be at least 1")
+	}
+
+	deployTimeout, err := time.ParseDuration(DEPLOY_TIMEOUT)
+	if err != nil {
+		panic(fmt.Errorf("parsing deploy timeout: %w", err))
+	}
+
+	if deployTimeout <= 0 {
+		panic("deploy timeout must be positive")
+	}
+
+	workers, err := strconv.Atoi(WORKERS)
+	if err != nil {
+		panic(fmt.Errorf("parsing workers: %w", err))
+	}
+
+	if workers < 1 {
+		panic("workers must be at least 1")
+	}
+
+	lease, err := time.ParseDuration(JOB_LEASE)
+	if err != nil {
+		panic(fmt.Errorf("parsing job lease: %w", err))
+	}
+
+	if lease <= heartbeatInterval {
+		panic(fmt.Sprintf("job lease must be longer than the heartbeat interval of %s", heartbeatInterval))
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "manager"
+	}
+
+	// the suffix keeps a restarted replica from being mistaken for its previous
+	// self while the old leases are still around
+	suffix := make([]byte, 4)
+	if _, err = rand.Read(suffix); err != nil {
+		panic(err)
+	}
+
 	manager := &wingman.Manager{}
 	registry := function.NewRegistry(manager)
 
 	return App{
-		clientset: clientset,
-		db:        db,
-		secret:    secret,
-		registry:  registry,
-		manager:   manager,
+		clientset:     clientset,
+		db:            db,
+		secret:        secret,
+		registry:      registry,
+		manager:       manager,
+		notifier:      db.NewNotifier(),
+		concurrency:   concurrency,
+		deployTimeout: deployTimeout,
+		workerId:      fmt.Sprintf("%s-%s", hostname, hex.EncodeToString(suffix)),
+		workers:       workers,
+		lease:         lease,
 	}
 }
 
@@ -90,6 +161,13 @@ func main() {
 	SetupLogger()
 
 	app := NewApp()
+	if err := app.resumeDeployments(context.Background()); err != nil {
+		slog.Error("failed to resume deployments", "err", err)
+	}
+
+	go app.notifier.Listen(context.Background())
+	app.startWorkers(context.Background())
+
 	r := router.NewRouter()
 
 	r.RouteFunc("/api", func(r *router.Router) {
@@ -103,6 +181,10 @@ func main() {
 			r.RouteFunc("/environment", func(r *router.Router) {
 				r.RouteFunc("/:slug", func(r *router.Router) {
 					r.Use(app.apiMiddleware).HandlePost("/deployment", router.ErrorHandlerFunc(app.createDeployment))
+					r.Use(app.apiMiddleware).HandlePost("/plan", router.ErrorHandlerFunc(app.planDeployment))
+					r.Use(app.apiMiddleware).HandlePost("/deployment/:id/rollback", router.ErrorHandlerFunc(app.rollbackDeployment))
+					r.Use(app.apiMiddleware).HandleGet("/deployment/:id", router.ErrorHandlerFunc(app.getDeploymentStatus))
+					r.Use(app.apiMiddleware).HandleGet("/deployment/:id/events", router.ErrorHandlerFunc(app.getDeploymentEvents))
 				})
 			})
 		})
@@ -121,12 +203,16 @@ func main() {
 		r.RouteFunc("/environment", func(r *router.Router) {
 			r.Use(app.userProtected).HandlePost("/", router.ErrorHandlerFunc(app.createEnvironmentHtmxRoute))
 			r.Use(app.userProtected).HandleDelete("/", router.ErrorHandlerFunc(app.deleteEnvironmentHtmxRoute))
+			r.Use(app.userProtected).HandlePost("/prune", router.ErrorHandlerFunc(app.pruneEnvironmentHtmxRoute))
+			r.Use(app.userProtected).HandlePost("/network-policies", router.ErrorHandlerFunc(app.networkPoliciesEnvironmentHtmxRoute))
+			r.Use(app.userProtected).HandlePost("/limits", router.ErrorHandlerFunc(app.limitsEnvironmentHtmxRoute))
 		})
 
 		r.RouteFunc("/deployment", func(r *router.Router) {
 			r.Use(app.userProtected).HandleGet("/", router.ErrorHandlerFunc(app.deploymentHtmxRoute))
 			r.Use(app.userProtected).HandleGet("/:id/status", router.ErrorHandlerFunc(app.deploymentStatusHtmxRoute))
 			r.Use(app.userProtected).HandlePost("/:id/config", router.ErrorHandlerFunc(app.updateDeploymentConfigHtmxRoute))
+			r.Use(app.userProtected).HandlePost("/:id/rollback", router.ErrorHandlerFunc(app.rollbackDeploymentHtmxRoute))
 		})
 
 		r.RouteFunc("/token", func(r *router.Router) {
@@ -144,6 +230,8 @@ func main() {
 
 	r.Use(app.userProtected).HandleGet("/deployment/:id", router.ErrorHandlerFunc(app.deploymentPage))
 	r.Use(app.userProtected).HandleGet("/environment", templ.Handler(templates.CreateEnvironment()))
+	r.Use(app.userProtected).HandleGet("/environment/:id/limits", router.ErrorHandlerFunc(app.environmentLimitsPage))
+	r.Use(app.userProtected).HandleGet("/admin/environments", router.ErrorHandlerFunc(app.adminEnvironmentsPage))
 	r.Use(app.userProtected).HandleGet("/tokens", router.ErrorHandlerFunc(app.tokenPage))
 
 	r.RouteFunc("/setup", func(r *router.Router) {
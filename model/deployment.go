@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/BSFishy/mora-manager/kube"
 )
 
 type DeploymentStatus string
@@ -20,6 +22,26 @@ const (
 	Success    DeploymentStatus = "success"
 )
 
+type FailurePhase string
+
+const (
+	PhaseEvaluate    FailurePhase = "evaluate"
+	PhaseWingman     FailurePhase = "wingman"
+	PhaseMaterialize FailurePhase = "materialize"
+	PhaseReadiness   FailurePhase = "readiness"
+)
+
+// Failure describes why a deployment errored. the service and phase are only
+// set if the error happened while deploying a specific service
+type Failure struct {
+	Message string       `json:"message"`
+	Module  *string      `json:"module,omitempty"`
+	Service *string      `json:"service,omitempty"`
+	Phase   FailurePhase `json:"phase,omitempty"`
+	// what the cluster had to say about a service that never became ready
+	Diagnostics *kube.Diagnostics `json:"diagnostics,omitempty"`
+}
+
 type Deployment struct {
 	Id                   string
 	EnvironmentId        string
@@ -27,12 +49,22 @@ type Deployment struct {
 	Status               DeploymentStatus
 	Config               json.RawMessage
 	State                *json.RawMessage
+	// resources that were deleted after the deployment succeeded because they
+	// were no longer part of the config
+	Pruned *json.RawMessage
+	// the earlier deployment whose config and state this deployment was created
+	// from, if it is a rollback
+	RollbackOfId *string
+	// human readable explanation for the current status, if there is one
+	StatusReason *string
+	// what went wrong, if the deployment errored. see Failure
+	Failure *json.RawMessage
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
-func (e *Environment) NewDeployment(ctx context.Context, d *DB, previousId *string, config any) (*Deployment, error) {
+func (e *Environment) NewDeployment(ctx context.Context, tx *sql.Tx, previousId *string, config any) (*Deployment, error) {
 	rawConfig, err := json.Marshal(config)
 	if err != nil {
 		return nil, fmt.Errorf("marshalling config: %w", err)
@@ -44,7 +76,36 @@ func (e *Environment) NewDeployment(ctx context.Context, d *DB, previousId *stri
 		Status:               NotStarted,
 		Config:               rawConfig,
 	}
-	err = d.db.QueryRowContext(ctx, "INSERT INTO deployments (environment_id, status, config, previous_deployment_id) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at", e.Id, NotStarted, rawConfig, previousId).Scan(&deployment.Id, &deployment.CreatedAt, &deployment.UpdatedAt)
+	err = tx.QueryRowContext(ctx, "INSERT INTO deployments (environment_id, status, config, previous_deployment_id) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at", e.Id, NotStarted, rawConfig, previousId).Scan(&deployment.Id, &deployment.CreatedAt, &deployment.UpdatedAt)
+	if err == nil {
+		return &deployment, nil
+	}
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return nil, err
+}
+
+// NewRollbackDeployment creates a deployment that reuses the config of an
+// earlier deployment, starting from the given state
+func (e *Environment) NewRollbackDeployment(ctx context.Context, tx *sql.Tx, previousId *string, source *Deployment, state any) (*Deployment, error) {
+	rawState, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling state: %w", err)
+	}
+
+	stateMessage := json.RawMessage(rawState)
+	deployment := Deployment{
+		EnvironmentId:        e.Id,
+		PreviousDeploymentId: previousId,
+		Status:               NotStarted,
+		Config:               source.Config,
+		State:                &stateMessage,
+		RollbackOfId:         &source.Id,
+	}
+	err = tx.QueryRowContext(ctx, "INSERT INTO deployments (environment_id, status, config, state, previous_deployment_id, rollback_of_id) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at", e.Id, NotStarted, source.Config, rawState, previousId, source.Id).Scan(&deployment.Id, &deployment.CreatedAt, &deployment.UpdatedAt)
 	if err == nil {
 		return &deployment, nil
 	}
@@ -58,8 +119,14 @@ func (e *Environment) NewDeployment(ctx context.Context, d *DB, previousId *stri
 
 // TODO: will we ever want to like undo a cancelled deployment or something like
 // that?
-func (e *Environment) CancelInProgressDeployments(ctx context.Context, d *DB) error {
-	_, err := d.db.ExecContext(ctx, "UPDATE deployments SET status = $1, updated_at = now() WHERE environment_id = $2 AND status IN ($3, $4, $5)", Cancelled, e.Id, NotStarted, InProgress, Waiting)
+// CancelInProgressDeployments cancels every unfinished deployment in the
+// environment and notifies whichever workers are running them. the
+// notifications only go out once the transaction commits
+func (e *Environment) CancelInProgressDeployments(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `WITH cancelled AS (
+			UPDATE deployments SET status = $1, updated_at = now() WHERE environment_id = $2 AND status IN ($3, $4, $5) RETURNING id
+		)
+		SELECT pg_notify($6, id::text) FROM cancelled`, Cancelled, e.Id, NotStarted, InProgress, Waiting, cancelChannel)
 	return err
 }
 
@@ -147,7 +214,7 @@ func (d *DB) GetDeployment(ctx context.Context, id string) (*Deployment, error)
 		Id: id,
 	}
 
-	err := d.db.QueryRowContext(ctx, "SELECT environment_id, previous_deployment_id, status, config, state, created_at, updated_at FROM deployments WHERE id = $1", id).Scan(&deployment.EnvironmentId, &deployment.PreviousDeploymentId, &deployment.Status, &deployment.Config, &deployment.State, &deployment.CreatedAt, &deployment.UpdatedAt)
+	err := d.db.QueryRowContext(ctx, "SELECT environment_id, previous_deployment_id, status, config, state, pruned, rollback_of_id, status_reason, failure, created_at, updated_at FROM deployments WHERE id = $1", id).Scan(&deployment.EnvironmentId, &deployment.PreviousDeploymentId, &deployment.Status, &deployment.Config, &deployment.State, &deployment.Pruned, &deployment.RollbackOfId, &deployment.StatusReason, &deployment.Failure, &deployment.CreatedAt, &deployment.UpdatedAt)
 	if err == nil {
 		return &deployment, nil
 	}
@@ -159,6 +226,31 @@ func (d *DB) GetDeployment(ctx context.Context, id string) (*Deployment, error)
 	return nil, err
 }
 
+// GetUnfinishedDeployments gets every deployment that some manager was working
+// on. deployments that are waiting for input aren't included since nothing is
+// running for them
+func (d *DB) GetUnfinishedDeployments(ctx context.Context) ([]Deployment, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, environment_id, previous_deployment_id, status, config, state, created_at, updated_at FROM deployments WHERE status IN ($1, $2) ORDER BY created_at", NotStarted, InProgress)
+	if err != nil {
+		return nil, fmt.Errorf("selecting rows: %w", err)
+	}
+
+	defer rows.Close()
+
+	result := []Deployment{}
+	for rows.Next() {
+		deployment := Deployment{}
+		err := rows.Scan(&deployment.Id, &deployment.EnvironmentId, &deployment.PreviousDeploymentId, &deployment.Status, &deployment.Config, &deployment.State, &deployment.CreatedAt, &deployment.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+
+		result = append(result, deployment)
+	}
+
+	return result, nil
+}
+
 func (d *Deployment) IsCancelled(ctx context.Context, db *DB) (bool, error) {
 	var status DeploymentStatus
 	err := db.db.QueryRowContext(ctx, "SELECT status FROM deployments WHERE id = $1", d.Id).Scan(&status)
@@ -182,6 +274,42 @@ func (d *Deployment) Lock(ctx context.Context, tx *sql.Tx) error {
 	return nil
 }
 
+// LockEnvironment waits until no other deployment in the environment is
+// running. a cancelled deployment holds on to this until it has actually
+// stopped, so the next one doesn't fight it over the same resources
+func (d *Deployment) LockEnvironment(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", hashStringToInt64(d.EnvironmentId))
+	if err != nil {
+		return fmt.Errorf("obtaining environment lock: %w", err)
+	}
+
+	return nil
+}
+
+// TryLockEnvironment is like LockEnvironment, but returns false instead of
+// waiting if another deployment is running
+func (d *Deployment) TryLockEnvironment(ctx context.Context, tx *sql.Tx) (bool, error) {
+	var ok bool
+	err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", hashStringToInt64(d.EnvironmentId)).Scan(&ok)
+	if err != nil {
+		return false, fmt.Errorf("obtaining environment lock: %w", err)
+	}
+
+	return ok, nil
+}
+
+// TryLock is like Lock, but returns false instead of waiting if someone else
+// is holding the lock
+func (d *Deployment) TryLock(ctx context.Context, tx *sql.Tx) (bool, error) {
+	var ok bool
+	err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", hashStringToInt64(fmt.Sprintf("%s/%s", d.EnvironmentId, d.Id))).Scan(&ok)
+	if err != nil {
+		return false, fmt.Errorf("obtaining lock: %w", err)
+	}
+
+	return ok, nil
+}
+
 func (d *Deployment) Refresh(ctx context.Context, tx *sql.Tx) error {
 	err := tx.QueryRowContext(ctx, "SELECT status, config, state FROM deployments WHERE id = $1", d.Id).Scan(&d.Status, &d.Config, &d.State)
 	return err
@@ -199,6 +327,30 @@ func (d *Deployment) UpdateStatusDb(ctx context.Context, db *DB, status Deployme
 	return err
 }
 
+func (d *Deployment) UpdateStatusWithReason(ctx context.Context, tx *sql.Tx, status DeploymentStatus, reason string) error {
+	_, err := tx.ExecContext(ctx, "UPDATE deployments SET status = $1, status_reason = $2, updated_at = now() WHERE id = $3", status, reason, d.Id)
+	return err
+}
+
+// Fail marks the deployment as errored, recording why
+func (d *Deployment) Fail(ctx context.Context, db *DB, failure Failure) error {
+	failureBlob, err := json.Marshal(failure)
+	if err != nil {
+		return fmt.Errorf("encoding failure: %w", err)
+	}
+
+	_, err = db.db.ExecContext(ctx, "UPDATE deployments SET status = $1, failure = $2, updated_at = now() WHERE id = $3", Errored, failureBlob, d.Id)
+	if err != nil {
+		return fmt.Errorf("updating database: %w", err)
+	}
+
+	failureMessage := json.RawMessage(failureBlob)
+	d.Status = Errored
+	d.Failure = &failureMessage
+
+	return nil
+}
+
 func (d *Deployment) UpdateState(ctx context.Context, tx *sql.Tx, state any) error {
 	stateBlob, err := json.Marshal(state)
 	if err != nil {
@@ -227,6 +379,20 @@ func (d *Deployment) UpdateConfig(ctx context.Context, tx *sql.Tx, config any) e
 	return nil
 }
 
+func (d *Deployment) UpdatePruned(ctx context.Context, tx *sql.Tx, pruned any) error {
+	prunedBlob, err := json.Marshal(pruned)
+	if err != nil {
+		return fmt.Errorf("encoding pruned resources: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE deployments SET pruned = $1, updated_at = now() WHERE id = $2", prunedBlob, d.Id)
+	if err != nil {
+		return fmt.Errorf("updating database: %w", err)
+	}
+
+	return nil
+}
+
 func (d *Deployment) UpdateStateAndStatus(ctx context.Context, tx *sql.Tx, status DeploymentStatus, state any) error {
 	stateBlob, err := json.Marshal(state)
 	if err != nil {
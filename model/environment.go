@@ -3,8 +3,11 @@ package model
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/BSFishy/mora-manager/def"
 )
 
 type Environment struct {
@@ -12,6 +15,13 @@ type Environment struct {
 	UserId string
 	Name   string
 	Slug   string
+	// whether resources that are no longer part of the config get deleted after
+	// a successful deployment
+	Prune bool
+	// whether services only accept traffic from the services that require them
+	NetworkPolicies bool
+	// quotas and default limits for the environment's namespace, set by admins
+	Limits def.Limits
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
@@ -25,7 +35,7 @@ func (d *DB) NewEnvironment(ctx context.Context, userId, name, slug string) (*En
 		Slug:   slug,
 	}
 
-	err := d.db.QueryRowContext(ctx, "INSERT INTO environments (user_id, name, slug) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at", userId, name, slug).Scan(&environment.Id, &environment.CreatedAt, &environment.UpdatedAt)
+	err := d.db.QueryRowContext(ctx, "INSERT INTO environments (user_id, name, slug) VALUES ($1, $2, $3) RETURNING id, prune, network_policies, created_at, updated_at", userId, name, slug).Scan(&environment.Id, &environment.Prune, &environment.NetworkPolicies, &environment.CreatedAt, &environment.UpdatedAt)
 	if err == nil {
 		return &environment, nil
 	}
@@ -38,7 +48,7 @@ func (d *DB) NewEnvironment(ctx context.Context, userId, name, slug string) (*En
 }
 
 func (d *DB) GetUserEnvironments(ctx context.Context, userId string) ([]Environment, error) {
-	rows, err := d.db.QueryContext(ctx, "SELECT id, name, slug, created_at, updated_at FROM environments WHERE user_id = $1 AND deleted_at IS NULL", userId)
+	rows, err := d.db.QueryContext(ctx, "SELECT id, name, slug, prune, network_policies, limits, created_at, updated_at FROM environments WHERE user_id = $1 AND deleted_at IS NULL", userId)
 	if err != nil {
 		return nil, fmt.Errorf("getting environments: %w", err)
 	}
@@ -49,11 +59,50 @@ func (d *DB) GetUserEnvironments(ctx context.Context, userId string) ([]Environm
 			UserId: userId,
 		}
 
-		err = rows.Scan(&environment.Id, &environment.Name, &environment.Slug, &environment.CreatedAt, &environment.UpdatedAt)
+		var limits []byte
+		err = rows.Scan(&environment.Id, &environment.Name, &environment.Slug, &environment.Prune, &environment.NetworkPolicies, &limits, &environment.CreatedAt, &environment.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scanning environment: %w", err)
+		}
+
+		if err = environment.decodeLimits(limits); err != nil {
+			return nil, err
+		}
+
+		environments = append(environments, environment)
+	}
+
+	return environments, nil
+}
+
+// OwnedEnvironment is an environment along with the username of its owner
+type OwnedEnvironment struct {
+	Environment
+	Owner string
+}
+
+// GetAllEnvironments gets the environments of every user, for admins
+func (d *DB) GetAllEnvironments(ctx context.Context) ([]OwnedEnvironment, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT e.id, e.user_id, u.username, e.name, e.slug, e.prune, e.network_policies, e.limits, e.created_at, e.updated_at FROM environments e JOIN users u ON u.id = e.user_id WHERE e.deleted_at IS NULL ORDER BY u.username, e.name")
+	if err != nil {
+		return nil, fmt.Errorf("getting environments: %w", err)
+	}
+	defer rows.Close()
+
+	environments := []OwnedEnvironment{}
+	for rows.Next() {
+		var environment OwnedEnvironment
+
+		var limits []byte
+		err = rows.Scan(&environment.Id, &environment.UserId, &environment.Owner, &environment.Name, &environment.Slug, &environment.Prune, &environment.NetworkPolicies, &limits, &environment.CreatedAt, &environment.UpdatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("scanning environment: %w", err)
 		}
 
+		if err = environment.decodeLimits(limits); err != nil {
+			return nil, err
+		}
+
 		environments = append(environments, environment)
 	}
 
@@ -65,9 +114,10 @@ func (d *DB) GetEnvironment(ctx context.Context, id string) (*Environment, error
 		Id: id,
 	}
 
-	err := d.db.QueryRowContext(ctx, "SELECT user_id, name, slug, created_at, updated_at FROM environments WHERE id = $1 AND deleted_at IS NULL", id).Scan(&environment.UserId, &environment.Name, &environment.Slug, &environment.CreatedAt, &environment.UpdatedAt)
+	var limits []byte
+	err := d.db.QueryRowContext(ctx, "SELECT user_id, name, slug, prune, network_policies, limits, created_at, updated_at FROM environments WHERE id = $1 AND deleted_at IS NULL", id).Scan(&environment.UserId, &environment.Name, &environment.Slug, &environment.Prune, &environment.NetworkPolicies, &limits, &environment.CreatedAt, &environment.UpdatedAt)
 	if err == nil {
-		return &environment, nil
+		return &environment, environment.decodeLimits(limits)
 	}
 
 	if err == sql.ErrNoRows {
@@ -83,9 +133,10 @@ func (d *DB) GetEnvironmentBySlug(ctx context.Context, userId, slug string) (*En
 		Slug:   slug,
 	}
 
-	err := d.db.QueryRowContext(ctx, "SELECT id, name, created_at, updated_at FROM environments WHERE user_id = $1 AND slug = $2 AND deleted_at IS NULL", userId, slug).Scan(&environment.Id, &environment.Name, &environment.CreatedAt, &environment.UpdatedAt)
+	var limits []byte
+	err := d.db.QueryRowContext(ctx, "SELECT id, name, prune, network_policies, limits, created_at, updated_at FROM environments WHERE user_id = $1 AND slug = $2 AND deleted_at IS NULL", userId, slug).Scan(&environment.Id, &environment.Name, &environment.Prune, &environment.NetworkPolicies, &limits, &environment.CreatedAt, &environment.UpdatedAt)
 	if err == nil {
-		return &environment, nil
+		return &environment, environment.decodeLimits(limits)
 	}
 
 	if err == sql.ErrNoRows {
@@ -99,3 +150,46 @@ func (e *Environment) Delete(ctx context.Context, d *DB) error {
 	_, err := d.db.ExecContext(ctx, "UPDATE environments SET deleted_at = now() WHERE id = $1", e.Id)
 	return err
 }
+
+func (e *Environment) SetPrune(ctx context.Context, d *DB, prune bool) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE environments SET prune = $1, updated_at = now() WHERE id = $2", prune, e.Id)
+	if err != nil {
+		return err
+	}
+
+	e.Prune = prune
+	return nil
+}
+
+func (e *Environment) SetNetworkPolicies(ctx context.Context, d *DB, networkPolicies bool) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE environments SET network_policies = $1, updated_at = now() WHERE id = $2", networkPolicies, e.Id)
+	if err != nil {
+		return err
+	}
+
+	e.NetworkPolicies = networkPolicies
+	return nil
+}
+
+func (e *Environment) SetLimits(ctx context.Context, d *DB, limits def.Limits) error {
+	limitsBlob, err := json.Marshal(limits)
+	if err != nil {
+		return fmt.Errorf("encoding limits: %w", err)
+	}
+
+	_, err = d.db.ExecContext(ctx, "UPDATE environments SET limits = $1, updated_at = now() WHERE id = $2", limitsBlob, e.Id)
+	if err != nil {
+		return err
+	}
+
+	e.Limits = limits
+	return nil
+}
+
+func (e *Environment) decodeLimits(limits []byte) error {
+	if err := json.Unmarshal(limits, &e.Limits); err != nil {
+		return fmt.Errorf("decoding limits: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type EventKind string
+
+const (
+	EventServiceStarted    EventKind = "service_started"
+	EventWingmanDeployed   EventKind = "wingman_deployed"
+	EventResourceCreated   EventKind = "resource_created"
+	EventResourceUpdated   EventKind = "resource_updated"
+	EventResourceRecreated EventKind = "resource_recreated"
+	EventWaitingForConfig  EventKind = "waiting_for_config"
+	EventServiceDeployed   EventKind = "service_deployed"
+	EventError             EventKind = "error"
+)
+
+// Event is something that happened while running a deployment. they're shown
+// to the user so they can follow along without needing access to the logs
+type Event struct {
+	Id           string
+	DeploymentId string
+	Kind         EventKind
+	// the service the event is about, if any
+	Module  *string
+	Service *string
+	Message string
+
+	CreatedAt time.Time
+}
+
+func (d *Deployment) AddEvent(ctx context.Context, db *DB, kind EventKind, module, service *string, message string) error {
+	_, err := db.db.ExecContext(ctx, "INSERT INTO deployment_events (deployment_id, kind, module, service, message) VALUES ($1, $2, $3, $4, $5)", d.Id, kind, module, service, message)
+	if err != nil {
+		return fmt.Errorf("inserting event: %w", err)
+	}
+
+	return nil
+}
+
+// GetEvents gets every event for the deployment, oldest first
+func (d *Deployment) GetEvents(ctx context.Context, db *DB) ([]Event, error) {
+	rows, err := db.db.QueryContext(ctx, "SELECT id, kind, module, service, message, created_at FROM deployment_events WHERE deployment_id = $1 ORDER BY seq", d.Id)
+	if err != nil {
+		return nil, fmt.Errorf("selecting rows: %w", err)
+	}
+
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		event := Event{
+			DeploymentId: d.Id,
+		}
+
+		if err = rows.Scan(&event.Id, &event.Kind, &event.Module, &event.Service, &event.Message, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,103 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+)
+
+// Job is a request for some manager replica to run a deployment. workers claim
+// jobs and hold a lease on them while they work. if a worker dies, its lease
+// expires and the job can be claimed by another worker
+type Job struct {
+	Id             string
+	DeploymentId   string
+	Status         JobStatus
+	Worker         *string
+	LeaseExpiresAt *time.Time
+	Attempts       int
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// EnqueueDeployment queues a deployment to be run by a worker. nothing is
+// queued if the deployment already has a job that is queued or running, which
+// a unique index makes sure of even when two enqueues race
+func (d *DB) EnqueueDeployment(ctx context.Context, deploymentId string) error {
+	_, err := d.db.ExecContext(ctx, enqueueDeploymentQuery, deploymentId, JobQueued)
+	return err
+}
+
+// EnqueueDeploymentTx queues a deployment as part of a transaction, so the job
+// only shows up if everything else in the transaction does too
+func EnqueueDeploymentTx(ctx context.Context, tx *sql.Tx, deploymentId string) error {
+	_, err := tx.ExecContext(ctx, enqueueDeploymentQuery, deploymentId, JobQueued)
+	return err
+}
+
+const enqueueDeploymentQuery = "INSERT INTO deployment_jobs (deployment_id, status) VALUES ($1, $2) ON CONFLICT DO NOTHING"
+
+// ClaimJob takes the oldest job that is either queued or whose lease has
+// expired. returns nil if there is nothing to do
+func (d *DB) ClaimJob(ctx context.Context, worker string, lease time.Duration) (*Job, error) {
+	job := Job{
+		Status: JobRunning,
+		Worker: &worker,
+	}
+
+	err := d.db.QueryRowContext(ctx, `UPDATE deployment_jobs
+		SET status = $1, worker = $2, lease_expires_at = now() + make_interval(secs => $3), attempts = attempts + 1, updated_at = now()
+		WHERE id = (
+			SELECT id FROM deployment_jobs
+			WHERE status = $4 OR (status = $1 AND lease_expires_at < now())
+			ORDER BY created_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, deployment_id, lease_expires_at, attempts, created_at, updated_at`, JobRunning, worker, lease.Seconds(), JobQueued).Scan(&job.Id, &job.DeploymentId, &job.LeaseExpiresAt, &job.Attempts, &job.CreatedAt, &job.UpdatedAt)
+	if err == nil {
+		return &job, nil
+	}
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return nil, err
+}
+
+// Heartbeat extends the job's lease. returns false if the lease was lost to
+// another worker, along with the current status of the deployment
+func (j *Job) Heartbeat(ctx context.Context, d *DB, lease time.Duration) (bool, DeploymentStatus, error) {
+	var status DeploymentStatus
+	err := d.db.QueryRowContext(ctx, `UPDATE deployment_jobs j
+		SET lease_expires_at = now() + make_interval(secs => $1), updated_at = now()
+		FROM deployments d
+		WHERE j.id = $2 AND j.worker = $3 AND j.status = $4 AND d.id = j.deployment_id
+		RETURNING d.status`, lease.Seconds(), j.Id, *j.Worker, JobRunning).Scan(&status)
+	if err == nil {
+		return true, status, nil
+	}
+
+	if err == sql.ErrNoRows {
+		return false, "", nil
+	}
+
+	return false, "", fmt.Errorf("extending lease: %w", err)
+}
+
+// Finish marks the job as done, as long as this worker still holds it
+func (j *Job) Finish(ctx context.Context, d *DB) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE deployment_jobs SET status = $1, lease_expires_at = NULL, updated_at = now() WHERE id = $2 AND worker = $3", JobDone, j.Id, *j.Worker)
+	return err
+}
@@ -3,6 +3,8 @@ package model
 import (
 	"context"
 	"fmt"
+	"maps"
+	"slices"
 )
 
 var migrations = map[string]string{
@@ -73,6 +75,53 @@ var migrations = map[string]string{
 		FOREIGN KEY (environment_id) REFERENCES environments(id),
 		FOREIGN KEY (previous_deployment_id) REFERENCES deployments(id)
 	);`,
+	"001-pruning": `ALTER TABLE environments ADD COLUMN prune BOOLEAN NOT NULL DEFAULT true;
+
+	ALTER TABLE deployments ADD COLUMN pruned JSONB;`,
+	"002-rollback":      `ALTER TABLE deployments ADD COLUMN rollback_of_id UUID REFERENCES deployments(id);`,
+	"003-status-reason": `ALTER TABLE deployments ADD COLUMN status_reason TEXT;`,
+	"004-jobs": `CREATE TABLE deployment_jobs (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		deployment_id UUID NOT NULL,
+		status TEXT NOT NULL,
+		worker TEXT,
+		lease_expires_at TIMESTAMPTZ,
+		attempts INTEGER NOT NULL DEFAULT 0,
+
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+		FOREIGN KEY (deployment_id) REFERENCES deployments(id)
+	);
+
+	CREATE INDEX deployment_jobs_status_idx ON deployment_jobs (status, created_at);`,
+	"005-events": `CREATE TABLE deployment_events (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		-- events can be written within the same instant, this keeps them in order
+		seq BIGSERIAL NOT NULL,
+		deployment_id UUID NOT NULL,
+		kind TEXT NOT NULL,
+		module TEXT,
+		service TEXT,
+		message TEXT NOT NULL,
+
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+		FOREIGN KEY (deployment_id) REFERENCES deployments(id)
+	);
+
+	CREATE INDEX deployment_events_deployment_idx ON deployment_events (deployment_id, seq);`,
+	"006-failure":          `ALTER TABLE deployments ADD COLUMN failure JSONB;`,
+	"007-limits":           `ALTER TABLE environments ADD COLUMN limits JSONB NOT NULL DEFAULT '{}';`,
+	"008-network-policies": `ALTER TABLE environments ADD COLUMN network_policies BOOLEAN NOT NULL DEFAULT false;`,
+	"009-unique-jobs": `DELETE FROM deployment_jobs j
+	USING deployment_jobs o
+	WHERE j.deployment_id = o.deployment_id
+		AND j.status IN ('queued', 'running')
+		AND o.status IN ('queued', 'running')
+		AND (o.created_at, o.id) < (j.created_at, j.id);
+
+	CREATE UNIQUE INDEX deployment_jobs_active_idx ON deployment_jobs (deployment_id) WHERE status IN ('queued', 'running');`,
 }
 
 func (d *DB) SetupMigrations(ctx context.Context) error {
@@ -89,7 +138,9 @@ func (d *DB) SetupMigrations(ctx context.Context) error {
 		return fmt.Errorf("getting migrations: %w", err)
 	}
 
-	for version, script := range migrations {
+	// migrations build on each other, so they need to run in version order
+	for _, version := range slices.Sorted(maps.Keys(migrations)) {
+		script := migrations[version]
 		if !includesMigration(version, dbMigrations) {
 			_, err = d.db.ExecContext(ctx, script)
 			if err != nil {
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/BSFishy/mora-manager/util"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgres channel that gets the id of every deployment that is cancelled
+const cancelChannel = "mora_deployment_cancelled"
+
+// Notifier listens for deployment cancellations on a dedicated connection and
+// hands them to whichever deployments in this replica are interested
+type Notifier struct {
+	db *DB
+
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+}
+
+func (d *DB) NewNotifier() *Notifier {
+	return &Notifier{
+		db:          d,
+		subscribers: map[string]map[chan struct{}]struct{}{},
+	}
+}
+
+// Subscribe returns a channel that receives a value when the deployment is
+// cancelled. the returned function must be called once the caller is no
+// longer interested
+func (n *Notifier) Subscribe(deploymentId string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.subscribers[deploymentId] == nil {
+		n.subscribers[deploymentId] = map[chan struct{}]struct{}{}
+	}
+
+	n.subscribers[deploymentId][ch] = struct{}{}
+
+	return ch, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		delete(n.subscribers[deploymentId], ch)
+		if len(n.subscribers[deploymentId]) == 0 {
+			delete(n.subscribers, deploymentId)
+		}
+	}
+}
+
+func (n *Notifier) dispatch(deploymentId string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for ch := range n.subscribers[deploymentId] {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// already has a pending notification
+		}
+	}
+}
+
+// Listen receives notifications until the context is cancelled, reconnecting
+// whenever the connection drops. notifications sent while reconnecting are
+// lost, so anything relying on them should still poll every now and then
+func (n *Notifier) Listen(ctx context.Context) {
+	logger := util.LogFromCtx(ctx)
+
+	for {
+		err := n.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		logger.Error("lost notification connection", "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (n *Notifier) listen(ctx context.Context) error {
+	conn, err := n.db.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("getting connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "LISTEN "+cancelChannel); err != nil {
+		return fmt.Errorf("listening: %w", err)
+	}
+
+	var listenErr error
+	_ = conn.Raw(func(driverConn any) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			listenErr = errors.New("unexpected driver connection")
+			return driver.ErrBadConn
+		}
+
+		for {
+			notification, err := c.Conn().WaitForNotification(ctx)
+			if err != nil {
+				listenErr = fmt.Errorf("waiting for notification: %w", err)
+				// the connection is still listening, so it shouldn't go back into
+				// the pool
+				return driver.ErrBadConn
+			}
+
+			n.dispatch(notification.Payload)
+		}
+	})
+
+	return listenErr
+}
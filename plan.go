@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/BSFishy/mora-manager/api"
+	"github.com/BSFishy/mora-manager/config"
+	"github.com/BSFishy/mora-manager/def"
+	"github.com/BSFishy/mora-manager/kube"
+	"github.com/BSFishy/mora-manager/model"
+	"github.com/BSFishy/mora-manager/point"
+	"github.com/BSFishy/mora-manager/router"
+	statepkg "github.com/BSFishy/mora-manager/state"
+)
+
+type ServicePlan struct {
+	Module       string              `json:"module"`
+	Service      string              `json:"service"`
+	Resources    []kube.ResourcePlan `json:"resources"`
+	ConfigPoints []point.Point       `json:"configPoints"`
+	// set when the service couldn't be evaluated, i.e. a wingman function that
+	// isn't available until the wingman is deployed
+	Error string `json:"error,omitempty"`
+}
+
+type PlanResponse struct {
+	Services []ServicePlan `json:"services"`
+	// resources that would be pruned. only known when the environment prunes and
+	// every service could be planned
+	Deletions []kube.ResourcePlan `json:"deletions"`
+}
+
+// planDeployment evaluates and materializes a config the same way a deployment
+// would, but only reports what would change. configs are assumed to be
+// inherited from the last successful deployment
+func (a *App) planDeployment(w http.ResponseWriter, req *http.Request) error {
+	var cfg api.Config
+	if err := json.NewDecoder(req.Body).Decode(&cfg); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	}
+
+	ctx := req.Context()
+	user, _ := model.GetUser(ctx)
+
+	params := router.Params(req)
+	environmentSlug := params["slug"]
+
+	environment, err := a.db.GetEnvironmentBySlug(ctx, user.Id, environmentSlug)
+	if err != nil {
+		return fmt.Errorf("getting environment: %w", err)
+	}
+
+	if environment == nil || environment.UserId != user.Id {
+		http.NotFound(w, req)
+		return nil
+	}
+
+	modelCtx := a.WithModel(user, environment)
+
+	services, err := config.ServiceConfigFromModules(ctx, modelCtx, cfg.Modules)
+	if err != nil {
+		if ok, err := writeDependencyError(w, err); ok {
+			return err
+		}
+
+		return fmt.Errorf("sorting services: %w", err)
+	}
+
+	configs, err := cfg.FlattenConfigs(ctx, modelCtx)
+	if err != nil {
+		return fmt.Errorf("flattening configs: %w", err)
+	}
+
+	previousDeployment, err := environment.GetLastDeployment(ctx, a.db)
+	if err != nil {
+		return fmt.Errorf("getting previous deployment: %w", err)
+	}
+
+	var state statepkg.State
+	if previousDeployment != nil && previousDeployment.State != nil {
+		var previousState statepkg.State
+		if err = json.Unmarshal(*previousDeployment.State, &previousState); err != nil {
+			return fmt.Errorf("decoding previous state: %w", err)
+		}
+
+		state.Configs = previousState.Configs
+	}
+
+	plannedConfig := config.Config{
+		Services: services,
+		Configs:  configs,
+	}
+
+	response := PlanResponse{
+		Services:  []ServicePlan{},
+		Deletions: []kube.ResourcePlan{},
+	}
+
+	// whatever the deployment would deploy is kept when pruning, along with the
+	// secrets behind the inherited configs
+	keep := state.Resources()
+	complete := true
+
+	for i := range plannedConfig.Services {
+		service := &plannedConfig.Services[i]
+
+		runwayCtx := &runwayContext{
+			manager:         a.manager,
+			clientset:       a.clientset,
+			registry:        a.registry,
+			user:            user.Username,
+			environment:     environment.Slug,
+			config:          &plannedConfig,
+			state:           &state,
+			moduleName:      service.ModuleName,
+			serviceName:     service.ServiceName,
+			networkPolicies: environment.NetworkPolicies,
+		}
+
+		plan, refs, err := a.planService(ctx, runwayCtx, service)
+		if err != nil {
+			return fmt.Errorf("planning %s/%s: %w", service.ModuleName, service.ServiceName, err)
+		}
+
+		if plan.Error != "" || len(plan.ConfigPoints) > 0 {
+			complete = false
+		}
+
+		response.Services = append(response.Services, plan)
+		keep = append(keep, refs...)
+	}
+
+	// a deployment that stops early doesn't prune, and a service that can't be
+	// planned doesn't say which of its resources it would keep
+	if environment.Prune && complete {
+		deleted, err := kube.Prune(ctx, modelCtx, keep, true)
+		if err != nil {
+			return fmt.Errorf("planning pruned resources: %w", err)
+		}
+
+		for _, ref := range deleted {
+			response.Deletions = append(response.Deletions, kube.ResourcePlan{
+				Kind:   ref.Kind,
+				Name:   ref.Name,
+				Action: kube.PlanDelete,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(response)
+}
+
+// planService mirrors deployService without deploying anything, also returning
+// the resources that would be deployed. evaluation errors are reported in the
+// plan, while errors talking to the cluster are returned
+func (a *App) planService(ctx context.Context, runwayCtx *runwayContext, service *config.ServiceConfig) (ServicePlan, []def.ResourceRef, error) {
+	plan := ServicePlan{
+		Module:       service.ModuleName,
+		Service:      service.ServiceName,
+		Resources:    []kube.ResourcePlan{},
+		ConfigPoints: []point.Point{},
+	}
+
+	wm, configPoints, err := service.EvaluateWingman(ctx, runwayCtx)
+	if err != nil {
+		plan.Error = fmt.Sprintf("evaluating wingman: %s", err)
+		return plan, nil, nil
+	}
+
+	if len(configPoints) > 0 {
+		plan.ConfigPoints = append(plan.ConfigPoints, configPoints...)
+		return plan, nil, nil
+	}
+
+	refs := []def.ResourceRef{}
+	if wm != nil {
+		mwm := wm.MaterializeWingman(runwayCtx)
+		resources, err := mwm.Plan(ctx, runwayCtx)
+		if err != nil {
+			return plan, nil, fmt.Errorf("planning wingman: %w", err)
+		}
+
+		plan.Resources = append(plan.Resources, resources...)
+		refs = append(refs, mwm.Refs()...)
+
+		// the wingman can only be asked for its config points if it's already
+		// running the way it would be after deploying
+		upToDate := true
+		for _, resource := range resources {
+			if resource.Action != kube.PlanUnchanged {
+				upToDate = false
+				break
+			}
+		}
+
+		if upToDate {
+			rwm, err := a.manager.FindWingman(ctx, runwayCtx)
+			if err != nil {
+				return plan, nil, fmt.Errorf("finding wingman: %w", err)
+			}
+
+			if rwm != nil {
+				cfp, err := rwm.GetConfigPoints(ctx, runwayCtx)
+				if err != nil {
+					return plan, nil, fmt.Errorf("getting wingman config points: %w", err)
+				}
+
+				if len(cfp) > 0 {
+					plan.ConfigPoints = append(plan.ConfigPoints, cfp...)
+					return plan, nil, nil
+				}
+			}
+		}
+	}
+
+	definition, configPoints, err := service.Evaluate(ctx, runwayCtx)
+	if err != nil {
+		plan.Error = fmt.Sprintf("evaluating service: %s", err)
+		return plan, nil, nil
+	}
+
+	if len(configPoints) > 0 {
+		plan.ConfigPoints = append(plan.ConfigPoints, configPoints...)
+		return plan, nil, nil
+	}
+
+	deployment := materialize(runwayCtx, service, definition)
+	resources, err := deployment.Plan(ctx, runwayCtx)
+	if err != nil {
+		return plan, nil, fmt.Errorf("planning service: %w", err)
+	}
+
+	plan.Resources = append(plan.Resources, resources...)
+	refs = append(refs, deployment.Refs()...)
+
+	return plan, refs, nil
+}
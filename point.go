@@ -7,7 +7,9 @@ import (
 	"github.com/BSFishy/mora-manager/config"
 	"github.com/BSFishy/mora-manager/core"
 	"github.com/BSFishy/mora-manager/expr"
+	"github.com/BSFishy/mora-manager/model"
 	"github.com/BSFishy/mora-manager/point"
+	"github.com/BSFishy/mora-manager/state"
 	"github.com/BSFishy/mora-manager/wingman"
 )
 
@@ -58,3 +60,51 @@ func FindConfigPoints(ctx context.Context, deps interface {
 
 	return configPoints, nil
 }
+
+type serviceConfigPoints struct {
+	runwayCtx    *runwayContext
+	configPoints point.Points
+}
+
+func (s serviceConfigPoints) Find(moduleName, identifier string) *point.Point {
+	return s.configPoints.Find(moduleName, identifier)
+}
+
+// findWaitingConfigPoints finds the config points for every service that is
+// waiting for configuration. since services are deployed in parallel, there
+// can be more than one of them
+func (a *App) findWaitingConfigPoints(ctx context.Context, user *model.User, env *model.Environment, cfg *config.Config, st *state.State) ([]serviceConfigPoints, error) {
+	result := []serviceConfigPoints{}
+	for i := range cfg.Services {
+		service := &cfg.Services[i]
+
+		ss := st.FindService(service.ModuleName, service.ServiceName)
+		if ss == nil || ss.Status != state.ServiceWaiting {
+			continue
+		}
+
+		runwayCtx := &runwayContext{
+			manager:     nil,
+			clientset:   a.clientset,
+			registry:    a.registry,
+			user:        user.Username,
+			environment: env.Slug,
+			config:      cfg,
+			state:       st,
+			moduleName:  service.ModuleName,
+			serviceName: service.ServiceName,
+		}
+
+		cfps, err := FindConfigPoints(ctx, runwayCtx, service)
+		if err != nil {
+			return nil, fmt.Errorf("finding config points for %s/%s: %w", service.ModuleName, service.ServiceName, err)
+		}
+
+		result = append(result, serviceConfigPoints{
+			runwayCtx:    runwayCtx,
+			configPoints: cfps,
+		})
+	}
+
+	return result, nil
+}
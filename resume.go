@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/BSFishy/mora-manager/config"
+	"github.com/BSFishy/mora-manager/model"
+	"github.com/BSFishy/mora-manager/util"
+)
+
+// resumeDeployments picks back up the deployments that were left running when
+// the manager last stopped. deployments that can't be resumed are marked as
+// errored with the reason why. deployments that still have a job are left to
+// the workers, which take over once the job's lease expires
+func (a *App) resumeDeployments(ctx context.Context) error {
+	logger := util.LogFromCtx(ctx)
+
+	deployments, err := a.db.GetUnfinishedDeployments(ctx)
+	if err != nil {
+		return fmt.Errorf("getting unfinished deployments: %w", err)
+	}
+
+	// creating a deployment cancels the older ones in the environment, so only
+	// the newest one is worth resuming. these are ordered by creation time
+	latest := map[string]string{}
+	for _, d := range deployments {
+		latest[d.EnvironmentId] = d.Id
+	}
+
+	for i := range deployments {
+		d := &deployments[i]
+
+		logger := logger.With("deployment", d.Id, "environment", d.EnvironmentId)
+		ctx := util.WithLogger(ctx, logger)
+
+		resume, err := a.recoverDeployment(ctx, d, latest[d.EnvironmentId] == d.Id)
+		if err != nil {
+			logger.Error("failed to recover deployment", "err", err)
+			continue
+		}
+
+		if resume {
+			logger.Info("resuming deployment")
+			if err = a.db.EnqueueDeployment(ctx, d.Id); err != nil {
+				logger.Error("failed to queue deployment", "err", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// recoverDeployment checks whether an orphaned deployment can be resumed,
+// marking it as finished if it can't
+func (a *App) recoverDeployment(ctx context.Context, d *model.Deployment, latest bool) (bool, error) {
+	logger := util.LogFromCtx(ctx)
+
+	resume := false
+	err := a.db.Transact(ctx, func(tx *sql.Tx) error {
+		locked, err := d.TryLock(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		if !locked {
+			// someone is actively working on this one, so it isn't orphaned
+			logger.Debug("deployment is locked, skipping")
+			return nil
+		}
+
+		if err = d.Refresh(ctx, tx); err != nil {
+			return fmt.Errorf("refreshing deployment: %w", err)
+		}
+
+		if d.Status != model.NotStarted && d.Status != model.InProgress {
+			return nil
+		}
+
+		if !latest {
+			return d.UpdateStatusWithReason(ctx, tx, model.Cancelled, "A newer deployment was started for this environment while the manager was restarting.")
+		}
+
+		environment, err := a.db.GetEnvironment(ctx, d.EnvironmentId)
+		if err != nil {
+			return fmt.Errorf("getting environment: %w", err)
+		}
+
+		if environment == nil {
+			return d.UpdateStatusWithReason(ctx, tx, model.Errored, "The environment was deleted while the manager was restarting.")
+		}
+
+		var cfg config.Config
+		if err = json.Unmarshal(d.Config, &cfg); err != nil {
+			return d.UpdateStatusWithReason(ctx, tx, model.Errored, fmt.Sprintf("The deployment could not be resumed after the manager restarted: decoding config: %s", err))
+		}
+
+		resume = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return resume, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"database/sql"
+	"github.com/BSFishy/mora-manager/kube"
+	"github.com/BSFishy/mora-manager/model"
+	"github.com/BSFishy/mora-manager/point"
+	"github.com/BSFishy/mora-manager/router"
+	statepkg "github.com/BSFishy/mora-manager/state"
+	k8serror "k8s.io/apimachinery/pkg/api/errors"
+)
+
+var (
+	errRollbackNotSuccessful = errors.New("can only roll back to a successful deployment")
+	errRollbackSecrets       = errors.New("can't roll back")
+)
+
+// rollback creates and starts a new deployment from the config and state of an
+// earlier successful deployment
+func (a *App) rollback(ctx context.Context, environment *model.Environment, source *model.Deployment) (*model.Deployment, error) {
+	if source.Status != model.Success {
+		return nil, errRollbackNotSuccessful
+	}
+
+	var state statepkg.State
+	if source.State != nil {
+		if err := json.Unmarshal(*source.State, &state); err != nil {
+			return nil, fmt.Errorf("decoding state: %w", err)
+		}
+	}
+
+	// every service gets deployed again, but the configs are reused as-is. that
+	// includes secret configs, which just point to the secrets that already exist
+	state.Services = nil
+
+	if err := a.checkRollbackSecrets(ctx, environment, &state); err != nil {
+		return nil, err
+	}
+
+	previousDeployment, err := environment.GetLastDeployment(ctx, a.db)
+	if err != nil {
+		return nil, fmt.Errorf("getting previous deployment: %w", err)
+	}
+
+	var previousDeploymentId *string
+	if previousDeployment != nil {
+		previousDeploymentId = &previousDeployment.Id
+	}
+
+	var deployment *model.Deployment
+	err = a.db.Transact(ctx, func(tx *sql.Tx) error {
+		if err := environment.CancelInProgressDeployments(ctx, tx); err != nil {
+			return fmt.Errorf("cancelling deployments: %w", err)
+		}
+
+		var err error
+		deployment, err = environment.NewRollbackDeployment(ctx, tx, previousDeploymentId, source, state)
+		if err != nil {
+			return fmt.Errorf("creating deployment: %w", err)
+		}
+
+		if err = model.EnqueueDeploymentTx(ctx, tx, deployment.Id); err != nil {
+			return fmt.Errorf("queueing deployment: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deployment, nil
+}
+
+// checkRollbackSecrets makes sure the secrets that the state points to still
+// hold what they did when the deployment ran. they could've been pruned since,
+// or overwritten by a newer deployment that used the same config
+func (a *App) checkRollbackSecrets(ctx context.Context, environment *model.Environment, state *statepkg.State) error {
+	user, err := a.db.GetUserById(ctx, environment.UserId)
+	if err != nil {
+		return fmt.Errorf("getting user: %w", err)
+	}
+
+	deps := a.WithModel(user, environment)
+	for _, config := range state.Configs {
+		if config.Kind != point.Secret {
+			continue
+		}
+
+		value, err := kube.GetSecret(ctx, deps, string(config.Value))
+		if k8serror.IsNotFound(err) {
+			return fmt.Errorf("%w: secret %s for %s/%s no longer exists", errRollbackSecrets, config.Value, config.ModuleName, config.Name)
+		}
+
+		if err != nil {
+			return fmt.Errorf("getting secret %s: %w", config.Value, err)
+		}
+
+		// deployments from before checksums were recorded can only be checked for
+		// the secret existing
+		if config.Checksum != "" && config.Checksum != statepkg.Checksum(value) {
+			return fmt.Errorf("%w: secret %s for %s/%s has been changed since", errRollbackSecrets, config.Value, config.ModuleName, config.Name)
+		}
+	}
+
+	return nil
+}
+
+func (a *App) rollbackDeployment(w http.ResponseWriter, req *http.Request) error {
+	ctx := req.Context()
+	user, _ := model.GetUser(ctx)
+
+	params := router.Params(req)
+	environmentSlug := params["slug"]
+	id := params["id"]
+
+	environment, err := a.db.GetEnvironmentBySlug(ctx, user.Id, environmentSlug)
+	if err != nil {
+		return fmt.Errorf("getting environment: %w", err)
+	}
+
+	if environment == nil || environment.UserId != user.Id {
+		http.NotFound(w, req)
+		return nil
+	}
+
+	source, err := a.db.GetDeployment(ctx, id)
+	if err != nil {
+		return fmt.Errorf("getting deployment: %w", err)
+	}
+
+	if source == nil || source.EnvironmentId != environment.Id {
+		http.NotFound(w, req)
+		return nil
+	}
+
+	deployment, err := a.rollback(ctx, environment, source)
+	if errors.Is(err, errRollbackNotSuccessful) || errors.Is(err, errRollbackSecrets) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(DeploymentResponse{
+		Id: deployment.Id,
+	})
+}
+
+func (a *App) rollbackDeploymentHtmxRoute(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user, _ := model.GetUser(ctx)
+
+	params := router.Params(r)
+	id := params["id"]
+
+	source, err := a.db.GetDeployment(ctx, id)
+	if err != nil {
+		return fmt.Errorf("getting deployment: %w", err)
+	}
+
+	if source == nil {
+		http.NotFound(w, r)
+		return nil
+	}
+
+	environment, err := a.db.GetEnvironment(ctx, source.EnvironmentId)
+	if err != nil {
+		return fmt.Errorf("getting environment: %w", err)
+	}
+
+	if environment == nil || environment.UserId != user.Id {
+		http.NotFound(w, r)
+		return nil
+	}
+
+	deployment, err := a.rollback(ctx, environment, source)
+	if errors.Is(err, errRollbackNotSuccessful) || errors.Is(err, errRollbackSecrets) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Hx-Location", fmt.Sprintf("/deployment/%s", deployment.Id))
+	return nil
+}
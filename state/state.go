@@ -1,10 +1,17 @@
 package state
 
-import "github.com/BSFishy/mora-manager/point"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/BSFishy/mora-manager/def"
+	"github.com/BSFishy/mora-manager/point"
+)
 
 type State struct {
-	Configs      []StateConfig
-	ServiceIndex int
+	Configs  []StateConfig
+	Services []ServiceState
 }
 
 // TODO: just use value.ServiceReferenceValue?
@@ -13,11 +20,41 @@ type ServiceRef struct {
 	Service string
 }
 
+func (s ServiceRef) String() string {
+	return fmt.Sprintf("%s/%s", s.Module, s.Service)
+}
+
 type StateConfig struct {
 	ModuleName string
 	Name       string
 	Kind       point.PointKind
 	Value      []byte
+	// for secrets, the checksum of what was put in the secret. secret names are
+	// reused, so this tells whether the secret still holds the same value
+	Checksum string `json:",omitempty"`
+}
+
+func Checksum(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
+
+type ServiceStatus string
+
+const (
+	ServiceWaiting  ServiceStatus = "waiting"
+	ServiceDeployed ServiceStatus = "deployed"
+)
+
+// ServiceState tracks the progress of a single service in a deployment.
+// services that haven't been touched yet don't have an entry
+type ServiceState struct {
+	Module  string
+	Service string
+	Status  ServiceStatus
+	// the resources that make up the service once it's deployed. anything in
+	// the namespace that isn't listed by some service can be pruned
+	Resources []def.ResourceRef
 }
 
 func (s *State) FindConfig(moduleName, name string) *StateConfig {
@@ -29,3 +66,54 @@ func (s *State) FindConfig(moduleName, name string) *StateConfig {
 
 	return nil
 }
+
+func (s *State) FindService(moduleName, serviceName string) *ServiceState {
+	for i := range s.Services {
+		service := &s.Services[i]
+		if service.Module == moduleName && service.Service == serviceName {
+			return service
+		}
+	}
+
+	return nil
+}
+
+func (s *State) SetServiceStatus(moduleName, serviceName string, status ServiceStatus) *ServiceState {
+	if service := s.FindService(moduleName, serviceName); service != nil {
+		service.Status = status
+		return service
+	}
+
+	s.Services = append(s.Services, ServiceState{
+		Module:  moduleName,
+		Service: serviceName,
+		Status:  status,
+	})
+
+	return &s.Services[len(s.Services)-1]
+}
+
+func (s *State) IsDeployed(ref ServiceRef) bool {
+	service := s.FindService(ref.Module, ref.Service)
+	return service != nil && service.Status == ServiceDeployed
+}
+
+// Resources lists the resources of every deployed service, along with the
+// secrets that hold config values
+func (s *State) Resources() []def.ResourceRef {
+	resources := []def.ResourceRef{}
+	for _, service := range s.Services {
+		resources = append(resources, service.Resources...)
+	}
+
+	for _, config := range s.Configs {
+		if config.Kind == point.Secret {
+			resources = append(resources, def.ResourceRef{
+				Kind: def.KindSecret,
+				Name: string(config.Value),
+			})
+		}
+	}
+
+	return resources
+}